@@ -0,0 +1,542 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+)
+
+// an arena entry is a header followed by the key and the serialized value:
+//
+//	size (uint32, of the whole entry) | expiresAt (int64) | timeAdded (int64) | hash (uint64) | keyLen (uint16)
+//
+// a size of 0 marks the end of the used part of a shard's buffer, when the next entry wrapped around to its start
+const (
+	arenaHeaderLen = 30
+	arenaMaxKeyLen = 1<<16 - 1
+	fnvOffset64    = 14695981039346656037
+	fnvPrime64     = 1099511628211
+)
+
+// ArenaStore - an in memory store that keeps serialized entries in large preallocated byte slices, indexed by maps
+// of offsets, in the style of bigcache.  The GC doesn't have to scan the entries (only the maps of integers), so
+// it suits an L1 of millions of small entries (the entries that expire are also in an expiry index, by hash).  Values are serialized like a RedisStore does, so Get needs a
+// pointer to a value of the type that was Set.
+//
+// The store is split into shards, each a ring buffer of maxBytes/shardCount bytes that evicts its oldest entries
+// to make room (whether they've been read or not).  Deleted, replaced and expired entries keep their space until
+// the ring gets back to them.  Expiry is the same as an InMemoryStore's: in seconds, with expired entries missed by
+// Get and removed by the janitor (which only visits the entries that are due, at most WithMaxExpiredPerTick per tick).  Keys are indexed by a 64 bit hash, so a key whose hash collides with another's
+// replaces it.
+type ArenaStore struct {
+	*arenaStore
+}
+
+type arenaStore struct {
+	shards     []*arenaShard
+	DefaultExp time.Duration
+	janitor    *janitor
+	// maxExpiredPerTick - the max number of expired entries removed by a DeleteExpired
+	maxExpiredPerTick int
+	// metrics - the store's counters (nil when the store was created without metrics)
+	metrics *storeMetrics
+	// registry - where the store's metrics are registered (nil when the store was created without metrics)
+	registry *metricsRegistry
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// arenaShard - a ring buffer of entries from head (the oldest) to tail (where the next one is written), which
+// wraps around to the start of buf
+type arenaShard struct {
+	mu    sync.RWMutex
+	index map[uint64]int
+	// expiry - the indexed entries that expire, by hash
+	expiry *expiryIndex[uint64]
+	buf    []byte
+	head   int
+	tail   int
+	// count - the number of entries in the ring (including dead ones)
+	count   int
+	metrics *storeMetrics
+}
+
+// ErrArenaKeyTooLong - keys are limited to 64KB
+var ErrArenaKeyTooLong = errors.New("cache: key is too long for an ArenaStore")
+
+// NewArenaStore - create a new arena store of maxBytes split into shardCount shards.  A cleanupInterval > 0 starts
+// a janitor that removes expired entries, and createMetric exports the same metrics as an InMemoryStore (with the
+// metricLabel, or "arena", as the store label).  Supported options: WithMaxExpiredPerTick, and WithRegisterer,
+// WithMetricNamespace and WithConstLabels for its metrics.
+func NewArenaStore(shardCount int, maxBytes int, defaultExpiration, cleanupInterval time.Duration, createMetric bool, metricLabel string, opt ...Option) (*ArenaStore, error) {
+	if shardCount <= 0 {
+		return nil, errors.New("cache.NewArenaStore: shardCount must be positive")
+	}
+	shardBytes := maxBytes / shardCount
+	if shardBytes < arenaHeaderLen {
+		return nil, fmt.Errorf("cache.NewArenaStore: maxBytes (%d) is too small for %d shards", maxBytes, shardCount)
+	}
+	opts := GetOpts(opt...)
+	c := &arenaStore{
+		shards:            make([]*arenaShard, shardCount),
+		DefaultExp:        defaultExpiration,
+		maxExpiredPerTick: opts[optionWithMaxExpiredPerTick].(int),
+	}
+	if createMetric {
+		name, label := "arena", "arena_cache_total_items_cnt"
+		if len(metricLabel) != 0 {
+			name, label = metricLabel, metricLabel
+		}
+		c.registry = newMetricsRegistry(opts)
+		c.metrics = newStoreMetrics(c.registry, name)
+		if err := c.registry.gaugeFunc(
+			func() float64 {
+				return float64(c.Len())
+			},
+			label,
+			fmt.Sprintf("Total count the number of items in the arena cache for %s", label)); err != nil {
+			c.registry.unregister()
+			return nil, err
+		}
+	}
+	for i := range c.shards {
+		c.shards[i] = &arenaShard{
+			index:   map[uint64]int{},
+			expiry:  newExpiryIndex[uint64](),
+			buf:     make([]byte, shardBytes),
+			metrics: c.metrics,
+		}
+	}
+	// see NewInMemoryStore for why the janitor runs on c and the finalizer is set on C
+	C := &ArenaStore{c}
+	if cleanupInterval > 0 {
+		c.janitor = runJanitor(c, cleanupInterval, c.metrics.janitorObserver())
+		runtime.SetFinalizer(C, stopArenaJanitor)
+	}
+	return C, nil
+}
+
+func stopArenaJanitor(c *ArenaStore) {
+	c.janitor.signal()
+}
+
+// fnv64a - FNV-1a of the key (without the allocations of hash/fnv)
+func fnv64a(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// shard - the shard for a key's hash
+func (c *arenaStore) shard(h uint64) *arenaShard {
+	return c.shards[h%uint64(len(c.shards))]
+}
+
+// expiresAt - the ExpiresAt of an entry set now with exp (0 when it never expires)
+func (c *arenaStore) expiresAt(now int64, exp time.Duration) int64 {
+	switch exp {
+	case persistence.FOREVER:
+		return 0
+	case persistence.DEFAULT:
+		exp = c.DefaultExp
+	}
+	return now + int64(exp/time.Second)
+}
+
+func arenaExpired(expiresAt int64) bool {
+	e := GenericCacheEntry{ExpiresAt: expiresAt}
+	return e.Expired()
+}
+
+// the fields of the entry at off
+func (s *arenaShard) size(off int) int        { return int(binary.BigEndian.Uint32(s.buf[off:])) }
+func (s *arenaShard) expiresAt(off int) int64 { return int64(binary.BigEndian.Uint64(s.buf[off+4:])) }
+func (s *arenaShard) timeAdded(off int) int64 { return int64(binary.BigEndian.Uint64(s.buf[off+12:])) }
+func (s *arenaShard) hash(off int) uint64     { return binary.BigEndian.Uint64(s.buf[off+20:]) }
+func (s *arenaShard) keyLen(off int) int      { return int(binary.BigEndian.Uint16(s.buf[off+28:])) }
+func (s *arenaShard) setExpiresAt(off int, e int64) {
+	binary.BigEndian.PutUint64(s.buf[off+4:], uint64(e))
+}
+
+func (s *arenaShard) key(off int) string {
+	return string(s.buf[off+arenaHeaderLen : off+arenaHeaderLen+s.keyLen(off)])
+}
+
+func (s *arenaShard) data(off int) []byte {
+	return s.buf[off+arenaHeaderLen+s.keyLen(off) : off+s.size(off)]
+}
+
+// lookup - the offset of key's entry, if it's there and hasn't expired (the caller must hold the lock)
+func (s *arenaShard) lookup(key string, h uint64) (int, bool) {
+	off, ok := s.index[h]
+	if !ok || s.key(off) != key {
+		return 0, false
+	}
+	return off, !arenaExpired(s.expiresAt(off))
+}
+
+// live - lookup for writers, removing the entry if it's expired (the caller must hold the write lock)
+func (s *arenaShard) live(key string, h uint64) (int, bool) {
+	off, ok := s.lookup(key, h)
+	if !ok {
+		if cur, found := s.index[h]; found && s.key(cur) == key {
+			delete(s.index, h)
+			s.expiry.remove(h)
+			s.metrics.evicted(EvictReasonExpired, 1)
+			s.metrics.expired(expiredOnRead)
+		}
+	}
+	return off, ok
+}
+
+// indexed - is the entry at off the indexed one for its hash
+func (s *arenaShard) indexed(h uint64, off int) bool {
+	cur, ok := s.index[h]
+	return ok && cur == off
+}
+
+// evictHead - remove the oldest entry in the ring (the caller must hold the write lock)
+func (s *arenaShard) evictHead() {
+	off := s.head
+	// the entry may be dead (deleted, replaced or expired), in which case the index has moved on
+	if h := s.hash(off); s.indexed(h, off) {
+		delete(s.index, h)
+		s.expiry.remove(h)
+		s.metrics.evicted(EvictReasonCapacity, 1)
+	}
+	s.head += s.size(off)
+	s.count--
+	if s.count == 0 {
+		s.head, s.tail = 0, 0
+	} else if len(s.buf)-s.head < arenaHeaderLen || s.size(s.head) == 0 {
+		s.head = 0
+	}
+}
+
+// alloc - the offset of n bytes at the tail of the ring, evicting the oldest entries to make room (the caller must
+// hold the write lock)
+func (s *arenaShard) alloc(n int) int {
+	for {
+		if s.count == 0 {
+			s.head, s.tail = 0, 0
+		}
+		switch {
+		case s.count > 0 && s.tail == s.head:
+			// the ring is full
+		case s.tail >= s.head:
+			if len(s.buf)-s.tail >= n {
+				return s.next(n)
+			}
+			if s.head >= n {
+				// wrap around to the start
+				if len(s.buf)-s.tail >= arenaHeaderLen {
+					binary.BigEndian.PutUint32(s.buf[s.tail:], 0)
+				}
+				s.tail = 0
+				return s.next(n)
+			}
+		default:
+			if s.head-s.tail >= n {
+				return s.next(n)
+			}
+		}
+		s.evictHead()
+	}
+}
+
+func (s *arenaShard) next(n int) int {
+	off := s.tail
+	s.tail += n
+	s.count++
+	return off
+}
+
+// put - write an entry and index it (the caller must hold the write lock)
+func (s *arenaShard) put(key string, h uint64, data []byte, timeAdded, expiresAt int64) error {
+	n := arenaHeaderLen + len(key) + len(data)
+	if n > len(s.buf) {
+		return ErrEntryTooLarge
+	}
+	// the old entry is being replaced, so it's not evicted if the ring gets to it
+	delete(s.index, h)
+	off := s.alloc(n)
+	binary.BigEndian.PutUint32(s.buf[off:], uint32(n))
+	binary.BigEndian.PutUint64(s.buf[off+4:], uint64(expiresAt))
+	binary.BigEndian.PutUint64(s.buf[off+12:], uint64(timeAdded))
+	binary.BigEndian.PutUint64(s.buf[off+20:], h)
+	binary.BigEndian.PutUint16(s.buf[off+28:], uint16(len(key)))
+	copy(s.buf[off+arenaHeaderLen:], key)
+	copy(s.buf[off+arenaHeaderLen+len(key):], data)
+	s.index[h] = off
+	s.expiry.set(h, expiresAt)
+	return nil
+}
+
+// Get - Get an entry.  value must be a pointer to a value of the type that was Set.  A GenericCacheEntry is stored
+// whole (like a RedisStore does), so a *GenericCacheEntry gets it back; for any other value it gets the entry's
+// times, with the serialized value as its Data.
+func (c *ArenaStore) Get(key string, value interface{}) error {
+	h := fnv64a(key)
+	s := c.shard(h)
+	s.mu.RLock()
+	off, ok := s.lookup(key, h)
+	if !ok {
+		expired := false
+		if cur, found := s.index[h]; found && s.key(cur) == key {
+			expired = true
+		}
+		s.mu.RUnlock()
+		if expired {
+			s.mu.Lock()
+			s.live(key, h)
+			s.mu.Unlock()
+		}
+		c.metrics.miss()
+		return persistence.ErrCacheMiss
+	}
+	// the value is copied out since the ring may reuse its space as soon as the lock is released
+	data := append([]byte(nil), s.data(off)...)
+	timeAdded, expiresAt := s.timeAdded(off), s.expiresAt(off)
+	s.mu.RUnlock()
+	c.metrics.hit()
+	if e, ok := value.(*GenericCacheEntry); ok {
+		var stored GenericCacheEntry
+		if err := utils.Deserialize(data, &stored); err == nil {
+			*e = stored
+			return nil
+		}
+		*e = GenericCacheEntry{Data: data, TimeAdded: timeAdded, ExpiresAt: expiresAt}
+		return nil
+	}
+	return utils.Deserialize(data, value)
+}
+
+// set - serialize and store the value, if the condition on the key's current entry holds
+func (c *ArenaStore) set(key string, value interface{}, exp time.Duration, cond func(exists bool) bool) error {
+	if len(key) > arenaMaxKeyLen {
+		return ErrArenaKeyTooLong
+	}
+	data, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	h := fnv64a(key)
+	s := c.shard(h)
+	now := time.Now().Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.live(key, h)
+	if !cond(exists) {
+		return persistence.ErrNotStored
+	}
+	if exists {
+		c.metrics.evicted(EvictReasonReplaced, 1)
+	}
+	if err := s.put(key, h, data, now, c.expiresAt(now, exp)); err != nil {
+		return err
+	}
+	c.metrics.set()
+	return nil
+}
+
+// Set - set an entry
+func (c *ArenaStore) Set(key string, value interface{}, exp time.Duration) error {
+	return c.set(key, value, exp, func(bool) bool { return true })
+}
+
+// Add - add an entry, if there isn't one for key
+func (c *ArenaStore) Add(key string, value interface{}, exp time.Duration) error {
+	return c.set(key, value, exp, func(exists bool) bool { return !exists })
+}
+
+// Replace - replace an entry, if there is one for key
+func (c *ArenaStore) Replace(key string, value interface{}, exp time.Duration) error {
+	return c.set(key, value, exp, func(exists bool) bool { return exists })
+}
+
+// Delete - delete an entry
+func (c *ArenaStore) Delete(key string) error {
+	h := fnv64a(key)
+	s := c.shard(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.live(key, h); !ok {
+		return persistence.ErrCacheMiss
+	}
+	delete(s.index, h)
+	s.expiry.remove(h)
+	c.metrics.evicted(EvictReasonDeleted, 1)
+	return nil
+}
+
+// Touch - set the expiry of an entry in place
+func (c *ArenaStore) Touch(key string, exp time.Duration) error {
+	h := fnv64a(key)
+	s := c.shard(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.live(key, h)
+	if !ok {
+		return persistence.ErrCacheMiss
+	}
+	expiresAt := c.expiresAt(time.Now().Unix(), exp)
+	s.setExpiresAt(off, expiresAt)
+	s.expiry.set(h, expiresAt)
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (c *ArenaStore) Increment(key string, n uint64) (uint64, error) {
+	return c.addToCounter(key, n, false)
+}
+
+// Decrement (see CacheStore interface)
+func (c *ArenaStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.addToCounter(key, n, true)
+}
+
+// addToCounter - atomically Increment/Decrement the counter for key.  Integers are serialized in decimal, so the
+// counter keeps its expiry and is rewritten (decrements are capped at 0, like an InMemoryStore's).
+func (c *ArenaStore) addToCounter(key string, n uint64, decrement bool) (uint64, error) {
+	h := fnv64a(key)
+	s := c.shard(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.live(key, h)
+	if !ok {
+		return 0, persistence.ErrCacheMiss
+	}
+	var current interface{}
+	if i, err := strconv.ParseInt(string(s.data(off)), 10, 64); err == nil {
+		current = i
+	} else if u, err := strconv.ParseUint(string(s.data(off)), 10, 64); err == nil {
+		current = u
+	} else {
+		return 0, fmt.Errorf("ArenaStore.Increment: the value of %s is not an integer", key)
+	}
+	_, newValue, err := addToInteger(current, n, decrement)
+	if err != nil {
+		return 0, err
+	}
+	data := []byte(strconv.FormatUint(newValue, 10))
+	if err := s.put(key, h, data, s.timeAdded(off), s.expiresAt(off)); err != nil {
+		return 0, err
+	}
+	return newValue, nil
+}
+
+// updateSealed - replace the sealed entry for key with update's result while holding its shard's lock, keeping its
+// expiry (see sealedUpdater)
+func (c *ArenaStore) updateSealed(key string, update func(sealed []byte) ([]byte, error)) error {
+	h := fnv64a(key)
+	s := c.shard(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.live(key, h)
+	if !ok {
+		return persistence.ErrCacheMiss
+	}
+	updated, err := update(append([]byte(nil), s.data(off)...))
+	if err != nil {
+		return err
+	}
+	return s.put(key, h, updated, s.timeAdded(off), s.expiresAt(off))
+}
+
+// Keys - get all the keys (skipping expired entries the janitor hasn't removed)
+func (c *ArenaStore) Keys() []interface{} {
+	var keys []interface{}
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, off := range s.index {
+			if !arenaExpired(s.expiresAt(off)) {
+				keys = append(keys, s.key(off))
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return keys
+}
+
+// Len - get the current count of entries in the cache (including expired entries the janitor hasn't removed)
+func (c *arenaStore) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		n += len(s.index)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Flush - delete all the entries
+func (c *ArenaStore) Flush() error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		c.metrics.evicted(EvictReasonFlushed, len(s.index))
+		s.index = map[uint64]int{}
+		s.expiry.reset()
+		s.head, s.tail, s.count = 0, 0, 0
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// DeleteExpired - remove up to maxExpiredPerTick of the entries that are due from the index (their space is reused
+// when the ring gets back to them)
+func (c *arenaStore) DeleteExpired() {
+	now, remaining := time.Now().Unix(), c.maxExpiredPerTick
+	for _, s := range c.shards {
+		if remaining <= 0 {
+			return
+		}
+		s.mu.Lock()
+		due := s.expiry.popDue(now, remaining)
+		for _, h := range due {
+			off, ok := s.index[h]
+			switch {
+			case !ok:
+			case arenaExpired(s.expiresAt(off)):
+				delete(s.index, h)
+				c.metrics.evicted(EvictReasonExpired, 1)
+				c.metrics.expired(expiredByJanitor)
+			default:
+				// popped at the very start of its ExpiresAt second, so it has to stay indexed
+				s.expiry.set(h, s.expiresAt(off))
+			}
+		}
+		s.mu.Unlock()
+		remaining -= len(due)
+	}
+}
+
+// Close - Shutdown, waiting at most defaultCloseTimeout
+func (c *ArenaStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown - stop the janitor and unregister the store's metrics.  It's idempotent, and it returns ctx's error if
+// ctx is done before the janitor stops.
+func (c *ArenaStore) Shutdown(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		if c.janitor != nil {
+			c.closeErr = c.janitor.Stop(ctx)
+		}
+		c.registry.unregister()
+	})
+	return c.closeErr
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+var newArenaStore = func(_ *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+	c, err := NewArenaStore(8, 1<<20, defaultExpiration, defCleanupInterval, false, "")
+	if err != nil {
+		panic("can't create arena store: " + err.Error())
+	}
+	return c
+}
+
+func TestArenaStore_TypicalGetSet(t *testing.T) {
+	anyTypes(t, newArenaStore)
+}
+
+func TestArenaStore_Expiration(t *testing.T) {
+	expiration(t, newArenaStore)
+}
+
+func TestArenaStore_IncrDecr(t *testing.T) {
+	incrDecr(t, newArenaStore)
+}
+
+func TestArenaStore_EmptyCache(t *testing.T) {
+	emptyCache(t, newArenaStore)
+}
+
+func TestArenaStore_Add(t *testing.T) {
+	testAdd(t, newArenaStore)
+}
+
+func TestArenaStore_Replace(t *testing.T) {
+	testReplace(t, newArenaStore)
+}
+
+func TestArenaStore_Ring(t *testing.T) {
+	c, err := NewArenaStore(1, 1024, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// entries of different sizes so the ring wraps at different offsets
+	for i := 0; i < 500; i++ {
+		if err := c.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("%0*d", i%40, i), persistence.DEFAULT); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if i%7 == 0 {
+			c.Delete(fmt.Sprintf("key-%d", i))
+		}
+	}
+	if n := c.Len(); n == 0 || n > 1024/arenaHeaderLen {
+		t.Errorf("expected the ring to hold some of the entries, got %d", n)
+	}
+	for _, k := range c.Keys() {
+		var value string
+		if err := c.Get(k.(string), &value); err != nil {
+			t.Fatalf("expected %s to be readable, got %s", k, err)
+		}
+		var i int
+		fmt.Sscanf(k.(string), "key-%d", &i)
+		if want := fmt.Sprintf("%0*d", i%40, i); value != want {
+			t.Errorf("expected %s to be %q, got %q", k, want, value)
+		}
+	}
+	var value string
+	if err := c.Get("key-499", &value); err != nil {
+		t.Errorf("expected the newest entry to be kept, got %s", err)
+	}
+	if err := c.Get("key-1", &value); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the oldest entry to be evicted, got %v", err)
+	}
+	if err := c.Set("big", make([]byte, 1024), persistence.DEFAULT); err != ErrEntryTooLarge {
+		t.Errorf("expected ErrEntryTooLarge, got %v", err)
+	}
+	c.Flush()
+	if c.Len() != 0 {
+		t.Errorf("expected Flush to empty the store, got %d entries", c.Len())
+	}
+}
+
+func TestArenaStore_DeleteExpired(t *testing.T) {
+	c, _ := NewArenaStore(1, 1<<16, time.Hour, 0, false, "", WithMaxExpiredPerTick(2))
+	for i := 0; i < 3; i++ {
+		c.Set(fmt.Sprintf("short-%d", i), "value", time.Second)
+	}
+	c.Set("long", "value", time.Hour)
+	c.Set("forever", "value", persistence.FOREVER)
+	c.Set("deleted", "value", time.Second)
+	c.Delete("deleted")
+	c.Set("touched", "value", time.Second)
+	c.Touch("touched", time.Hour)
+	if n := c.shards[0].expiry.Len(); n != 5 {
+		t.Errorf("expected the 5 entries that expire to be indexed, got %d", n)
+	}
+	time.Sleep(2 * time.Second)
+	if keys := c.Keys(); len(keys) != 3 {
+		t.Errorf("expected Keys to skip the expired entries, got %v", keys)
+	}
+
+	// the janitor only removes maxExpiredPerTick of the due entries a tick
+	c.DeleteExpired()
+	if c.Len() != 4 {
+		t.Errorf("expected 2 of the expired entries to be removed, got %d entries", c.Len())
+	}
+	c.DeleteExpired()
+	if c.Len() != 3 {
+		t.Errorf("expected the expired entries to be removed, got %d entries", c.Len())
+	}
+	if n := c.shards[0].expiry.Len(); n != 2 {
+		t.Errorf("expected only the entries that haven't expired to be indexed, got %d", n)
+	}
+}
+
+func TestGenericCache_ArenaStore(t *testing.T) {
+	gob.Register(testStruct{})
+	for _, encrypt := range []bool{false, true} {
+		store, _ := NewArenaStore(4, 1<<20, time.Hour, 0, false, "")
+		c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), encrypt)
+		if err := c.Set("struct", testStruct{Name: "foo", Count: 2}, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var got testStruct
+		if err := c.Get("struct", &got); err != nil || got.Name != "foo" {
+			t.Errorf("expected foo, got %v and %v", got, err)
+		}
+		if err := c.SetWithOptions("pii", "secret", time.Minute, WithEntryEncryption(true)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var pii string
+		if err := c.Get("pii", &pii); err != nil || pii != "secret" {
+			t.Errorf("expected secret, got %q and %v", pii, err)
+		}
+		if err := c.Set("counter", 1, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n, err := c.Increment("counter", 2); err != nil || n != 3 {
+			t.Errorf("expected 3, got %d and %v", n, err)
+		}
+
+		// the README's pattern of caching GenericCacheEntries
+		setEntry := c.NewGenericCacheEntry(testStruct{Name: "bar", Count: 3}, time.Minute)
+		if err := c.Set("entry", setEntry, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var gotEntry GenericCacheEntry
+		if err := c.Get("entry", &gotEntry); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got, ok := gotEntry.Data.(testStruct); !ok || got.Name != "bar" || gotEntry.ExpiresAt != setEntry.ExpiresAt {
+			t.Errorf("expected %v, got %v", setEntry, gotEntry)
+		}
+
+		c.SetWithOptions("session", "data", 10*time.Minute, WithEntrySliding(true))
+		store.Touch("session", time.Minute)
+		var session string
+		if err := c.Get("session", &session); err != nil || session != "data" {
+			t.Fatalf("expected data, got %q and %v", session, err)
+		}
+		var entry GenericCacheEntry
+		store.Get("session", &entry)
+		if remaining := time.Until(time.Unix(entry.ExpiresAt, 0)); remaining < 9*time.Minute {
+			t.Errorf("expected the read to slide the expiry, got %v", remaining)
+		}
+	}
+}
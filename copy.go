@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"reflect"
+	"sync"
+)
+
+// deepCopy - a copy of v that shares no mutable memory with it: pointers, slices, maps and interfaces are copied
+// recursively (pointer cycles and shared pointers are preserved).  Values of immutable kinds (numbers, strings,
+// etc) are returned as is, without allocating.  Unexported struct fields can't be set via reflection, so they're
+// copied shallowly, and channels and funcs are shared.
+func deepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if !needsCopy(rv.Type()) {
+		return v
+	}
+	return copyValue(rv, map[uintptr]reflect.Value{}).Interface()
+}
+
+// copiedTypes - the needsCopy result for struct types (reflect.Type -> bool), since they have to walk the fields
+var copiedTypes sync.Map
+
+// needsCopy - can a value of type t refer to mutable memory that deepCopy copies
+func needsCopy(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	case reflect.Array:
+		return needsCopy(t.Elem())
+	case reflect.Struct:
+		if needs, ok := copiedTypes.Load(t); ok {
+			return needs.(bool)
+		}
+		needs := false
+		for i := 0; i < t.NumField() && !needs; i++ {
+			f := t.Field(i)
+			needs = f.PkgPath == "" && needsCopy(f.Type)
+		}
+		copiedTypes.Store(t, needs)
+		return needs
+	}
+	return false
+}
+
+func copyValue(v reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if c, ok := seen[v.Pointer()]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[v.Pointer()] = c
+		c.Elem().Set(copyValue(v.Elem(), seen))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if !needsCopy(v.Type().Elem()) {
+			reflect.Copy(c, v)
+			return c
+		}
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), seen))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			// keys are compared by value (or by pointer), so they're kept as is
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value(), seen))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem(), seen))
+		return c
+	case reflect.Array:
+		if !needsCopy(v.Type().Elem()) {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), seen))
+		}
+		return c
+	case reflect.Struct:
+		if !needsCopy(v.Type()) {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() && needsCopy(f.Type()) {
+				f.Set(copyValue(v.Field(i), seen))
+			}
+		}
+		return c
+	}
+	return v
+}
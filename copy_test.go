@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+type copyNode struct {
+	Name     string
+	Tags     []string
+	Attrs    map[string][]int
+	Next     *copyNode
+	Any      interface{}
+	Fixed    [2][]byte
+	internal []int
+}
+
+func TestDeepCopy(t *testing.T) {
+	for _, v := range []interface{}{nil, 1, "s", 1.5, struct{ A, B int }{1, 2}} {
+		if c := deepCopy(v); !reflect.DeepEqual(c, v) {
+			t.Errorf("expected %v, got %v", v, c)
+		}
+	}
+
+	n := &copyNode{
+		Name:     "a",
+		Tags:     []string{"x"},
+		Attrs:    map[string][]int{"k": {1}},
+		Any:      []int{1},
+		Fixed:    [2][]byte{[]byte("b")},
+		internal: []int{1},
+	}
+	n.Next = n // a cycle
+	c := deepCopy(n).(*copyNode)
+	if !reflect.DeepEqual(c.Tags, n.Tags) || !reflect.DeepEqual(c.Attrs, n.Attrs) || c.Next != c {
+		t.Fatalf("expected an equal copy with the cycle preserved, got %+v", c)
+	}
+	c.Tags[0] = "changed"
+	c.Attrs["k"][0] = 2
+	c.Any.([]int)[0] = 2
+	c.Fixed[0][0] = 'c'
+	if n.Tags[0] != "x" || n.Attrs["k"][0] != 1 || n.Any.([]int)[0] != 1 || n.Fixed[0][0] != 'b' {
+		t.Errorf("expected the original to be unchanged, got %+v", n)
+	}
+	// unexported fields are shallow
+	if &c.internal[0] != &n.internal[0] {
+		t.Errorf("expected unexported fields to be shared")
+	}
+
+	m := map[string]interface{}{"list": []string{"a"}}
+	mc := deepCopy(m).(map[string]interface{})
+	mc["list"].([]string)[0] = "b"
+	mc["new"] = 1
+	if m["list"].([]string)[0] != "a" || len(m) != 1 {
+		t.Errorf("expected the original map to be unchanged, got %v", m)
+	}
+}
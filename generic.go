@@ -1,22 +1,29 @@
 package cache
 
 import (
-	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/Bose/cache/persistence"
-	"github.com/Bose/go-cache/galapagos_gin/cache"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrIntegrityCheckFailed - the entry's signature didn't match, so it was forged or corrupted (and has been evicted)
+	ErrIntegrityCheckFailed = errors.New("cache: integrity check failed")
+)
+
 func init() {
 	gob.Register(GenericCacheEntry{})
 }
@@ -25,21 +32,43 @@ func init() {
 //  - Cache: empty interface to a persistent cache pool (could be writable if cType == Writable)
 //  - ReadCache: empty interface to a persistent read-only cache pool (cType == ReadOnly)
 //  - sharedSecret: used by GetKey() for generating signatures to be used as an entries primary key
+//  - keyProvider: supplies the key used to encrypt/decrypt entries when EncryptData is true
 //  - DefaultExp: the default expiry for entries
 //  - cType: Writable or ReadOnly
 //  - cLevel: L1 (level 1) or L2 (level 2)
 //  - KeyPrefix: a prefex added to each key that's generated by GetKey()
 //  - Logger: the logger to use when writing logs
+//  - EncryptData: encrypt every entry
+//  - SignData: append an HMAC (using the sharedSecret) to every entry and check it on Get
+//  - metrics: the operation metrics (nil unless WithOperationMetrics)
+//  - registry: where the operation metrics are registered (see WithRegisterer)
+//  - ctx: the context whose span the operations are traced in (nil unless WithContext)
 type GenericCache struct {
 	Cache        interface{}
 	ReadCache    *GenericCache
 	sharedSecret []byte
+	keyProvider  KeyProvider
 	DefaultExp   time.Duration
 	cType        Type
 	cLevel       Level
 	KeyPrefix    []byte
 	Logger       *logrus.Entry
 	EncryptData  bool
+	SignData     bool
+	jitter       expiryJitter
+	sliding      bool
+	maxLifetime  time.Duration
+	bus          *InvalidationBus
+	metrics      *cacheMetrics
+	registry     *metricsRegistry
+	ctx          context.Context
+	closer       *cacheCloser
+}
+
+// cacheCloser - a cache's Shutdown state, which is shared with the caches returned by WithContext
+type cacheCloser struct {
+	once sync.Once
+	err  error
 }
 
 // GenericCacheEntry - represents a cached entry...
@@ -50,35 +79,66 @@ type GenericCacheEntry struct {
 	Data      interface{}
 	TimeAdded int64
 	ExpiresAt int64
+
+	// exp and jitter - the expiry NewGenericCacheEntry made the entry with and how much it was shortened by.  They
+	// aren't stored: they're only so a Set of the entry with the same expiry shortens its TTL by the same amount,
+	// instead of jittering it again.
+	exp, jitter time.Duration
 }
 
 // NewCacheWithPool - creates a new generic cache for microservices using a Pool for connecting (this cache should be read/write)
-func NewCacheWithPool(cachePool interface{}, cType Type, cLevel Level, sharedSecret string, expirySeconds int, keyPrefix []byte, encryptData bool) *GenericCache {
+func NewCacheWithPool(cachePool interface{}, cType Type, cLevel Level, sharedSecret string, expirySeconds int, keyPrefix []byte, encryptData bool, opt ...Option) *GenericCache {
+	opts := GetOpts(opt...)
 	storeExp := time.Duration(expirySeconds) * time.Second
+	keyProvider, ok := opts[optionWithKeyProvider].(KeyProvider)
+	if !ok || keyProvider == nil {
+		keyProvider = NewStaticKeyProvider([]byte(sharedSecret))
+	}
+	signData, _ := opts[optionWithSignData].(bool)
+	var metrics *cacheMetrics
+	var registry *metricsRegistry
+	if opts[optionWithOperationMetrics].(bool) {
+		registry = newMetricsRegistry(opts)
+		metrics = newCacheMetrics(registry, cLevel, cType, keyPrefix)
+	}
+	acquireStore(cachePool)
 	return &GenericCache{
 		Cache:        cachePool,
 		sharedSecret: []byte(sharedSecret),
+		keyProvider:  keyProvider,
 		DefaultExp:   storeExp,
 		cType:        cType,
 		cLevel:       cLevel,
 		KeyPrefix:    keyPrefix,
 		EncryptData:  encryptData,
+		SignData:     signData,
+		jitter:       newExpiryJitter(opts),
+		sliding:      opts[optionWithSlidingExpiration].(bool),
+		maxLifetime:  opts[optionWithMaxLifetime].(time.Duration),
+		bus:          opts[optionWithInvalidationBus].(*InvalidationBus),
+		metrics:      metrics,
+		registry:     registry,
+		closer:       &cacheCloser{},
 	}
 }
 
 // NewCacheWithMultiPools - creates a new generic cache for microservices using two Pools.  One pool for writes and a separate pool for reads
-func NewCacheWithMultiPools(writeCachePool interface{}, readCachePool interface{}, cLevel Level, sharedSecret string, expirySeconds int, keyPrefix []byte, encryptData bool) *GenericCache {
-	c := NewCacheWithPool(writeCachePool, Writable, cLevel, sharedSecret, expirySeconds, keyPrefix, encryptData)
-	c.ReadCache = NewCacheWithPool(readCachePool, ReadOnly, cLevel, sharedSecret, expirySeconds, keyPrefix, encryptData)
+func NewCacheWithMultiPools(writeCachePool interface{}, readCachePool interface{}, cLevel Level, sharedSecret string, expirySeconds int, keyPrefix []byte, encryptData bool, opt ...Option) *GenericCache {
+	c := NewCacheWithPool(writeCachePool, Writable, cLevel, sharedSecret, expirySeconds, keyPrefix, encryptData, opt...)
+	c.ReadCache = NewCacheWithPool(readCachePool, ReadOnly, cLevel, sharedSecret, expirySeconds, keyPrefix, encryptData, opt...)
 	return c
 }
 
-// entrySignature - used for the cache primary key
-func entrySignature(entry []byte, secret []byte) string {
+// entryMAC - HMAC-SHA256 of the entry
+func entryMAC(entry []byte, secret []byte) []byte {
 	mac := hmac.New(sha256.New, secret)
 	mac.Write(entry)
-	sig := mac.Sum(nil)
-	return hex.EncodeToString(sig)
+	return mac.Sum(nil)
+}
+
+// entrySignature - used for the cache primary key
+func entrySignature(entry []byte, secret []byte) string {
+	return hex.EncodeToString(entryMAC(entry, secret))
 }
 
 func keyAndIV(secret []byte) (key []byte, IV []byte, err error) {
@@ -118,6 +178,9 @@ func decryptByteArray(data []byte, secret []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("cache.decryptByteArray: data len %d is not a multiple of the block size", len(data))
+	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -130,44 +193,51 @@ func decryptByteArray(data []byte, secret []byte) ([]byte, error) {
 }
 
 func (c *GenericCache) encryptEntry(data []byte) ([]byte, error) {
-	// return data, nil
-	if c.EncryptData {
-		// fmt.Println("encrypt input: ", data)
-		paddedData := PKCS7.Padding([]byte(data), 16)
-		// fmt.Println("encrypt padded: ", paddedData)
-		// fmt.Println("encrypt padded len: ", len(paddedData))
-		// fmt.Println("encrypt secret: ", c.SharedSecret)
-		encrypted, cryptErr := encryptByteArray(paddedData, c.sharedSecret)
-		if cryptErr != nil {
-			err := fmt.Errorf("GenericCache.encryptEntry: can't encrypt data: %s", cryptErr.Error())
-			c.logError(err.Error())
-			return nil, err
-		}
-		// fmt.Println("encrypt encrypted: ", encrypted)
-		return []byte(encrypted), nil
+	defer c.stage(stageEncrypt)()
+	// fmt.Println("encrypt input: ", data)
+	paddedData := PKCS7.Padding([]byte(data), 16)
+	// fmt.Println("encrypt padded: ", paddedData)
+	// fmt.Println("encrypt padded len: ", len(paddedData))
+	// fmt.Println("encrypt secret: ", c.SharedSecret)
+	key, keyErr := c.keyProvider.Key()
+	if keyErr != nil {
+		err := fmt.Errorf("GenericCache.encryptEntry: can't get key: %s", keyErr.Error())
+		c.logError(err.Error())
+		return nil, err
 	}
-	return data, nil
+	encrypted, cryptErr := encryptByteArray(paddedData, key)
+	if cryptErr != nil {
+		err := fmt.Errorf("GenericCache.encryptEntry: can't encrypt data: %s", cryptErr.Error())
+		c.logError(err.Error())
+		return nil, err
+	}
+	// fmt.Println("encrypt encrypted: ", encrypted)
+	return []byte(encrypted), nil
 }
 func (c *GenericCache) decryptEntry(data []byte) ([]byte, error) {
-	if c.EncryptData {
-		// fmt.Println("decrypt encrypted: ", data)
-		// fmt.Println("encrypt secret: ", c.SharedSecret)
-		decryptedData, cryptErr := decryptByteArray(data, c.sharedSecret)
-		// fmt.Println("decrypt decrypted: ", decryptedData)
-		if cryptErr != nil {
-			err := fmt.Errorf("GenericCache.decryptEntry: can't decrypt data: %s", cryptErr.Error())
-			c.logError(err.Error())
-			return nil, err
-		}
-		unpaddedData, cryptErr := PKCS7.Unpadding([]byte(decryptedData), 16)
-		if cryptErr != nil {
-			err := fmt.Errorf("GenericCache.decryptEntry: can't unpadding error: %s", cryptErr.Error())
-			c.logError(err.Error())
-			return nil, err
-		}
-		return unpaddedData, nil
+	defer c.stage(stageDecrypt)()
+	// fmt.Println("decrypt encrypted: ", data)
+	// fmt.Println("encrypt secret: ", c.SharedSecret)
+	key, keyErr := c.keyProvider.Key()
+	if keyErr != nil {
+		err := fmt.Errorf("GenericCache.decryptEntry: can't get key: %s", keyErr.Error())
+		c.logError(err.Error())
+		return nil, err
+	}
+	decryptedData, cryptErr := decryptByteArray(data, key)
+	// fmt.Println("decrypt decrypted: ", decryptedData)
+	if cryptErr != nil {
+		err := fmt.Errorf("GenericCache.decryptEntry: can't decrypt data: %s", cryptErr.Error())
+		c.logError(err.Error())
+		return nil, err
 	}
-	return data, nil
+	unpaddedData, cryptErr := PKCS7.Unpadding([]byte(decryptedData), 16)
+	if cryptErr != nil {
+		err := fmt.Errorf("GenericCache.decryptEntry: can't unpadding error: %s", cryptErr.Error())
+		c.logError(err.Error())
+		return nil, err
+	}
+	return unpaddedData, nil
 }
 
 // Expired - is the entry expired?
@@ -179,17 +249,20 @@ func (e *GenericCacheEntry) Expired() bool {
 	return t.Before(time.Now())
 }
 
-// NewGenericCacheEntry creates an entry with the data and all the time attribs set
-func (c *GenericCache) NewGenericCacheEntry(data interface{}, exp time.Duration) GenericCacheEntry {
+// NewGenericCacheEntry creates an entry with the data and all the time attribs set (the expiry is jittered, unless
+// WithExactExpiry is passed - see WithExpiryJitter).  When the entry is Set with the same expiry, its TTL is shortened
+// by the same jitter.
+func (c *GenericCache) NewGenericCacheEntry(data interface{}, exp time.Duration, opt ...EntryOption) GenericCacheEntry {
 	var t time.Duration
 	if exp != 0 {
 		t = exp
 	} else {
 		t = c.DefaultExp
 	}
+	jittered, _ := c.entryExpiry(t, getEntryOpts(opt...))
 	now := time.Now().Unix()
-	expiresAt := now + int64(t/time.Second) // convert from nanoseconds
-	return GenericCacheEntry{Data: data, TimeAdded: now, ExpiresAt: expiresAt}
+	expiresAt := now + int64(jittered/time.Second) // convert from nanoseconds
+	return GenericCacheEntry{Data: data, TimeAdded: now, ExpiresAt: expiresAt, exp: t, jitter: t.Truncate(time.Second) - jittered}
 }
 
 // GetKey - return a key for the entryData
@@ -225,11 +298,19 @@ func (c *GenericCache) debugEntry(key string, e GenericCacheEntry) {
 }
 
 // AddExistingEntry -
-func (c *GenericCache) AddExistingEntry(key string, entry GenericCacheEntry, expiresAt int64) error {
+func (c *GenericCache) AddExistingEntry(key string, entry GenericCacheEntry, expiresAt int64) (err error) {
+	defer c.metrics.observe(opAddExistingEntry, time.Now(), &err)
+	c, span := c.startSpan(opAddExistingEntry)
+	defer span.finish(&err)
 	//	return nil // disable the L1 cache
 	c.logDebug(fmt.Sprintf("GenericCache.AddExistingEntry: L%v/T%v key == %s", c.cLevel, c.cType, key))
 	expCacheAt := time.Duration(expiresAt-time.Now().Unix()) * time.Second
-	if err := c.Cache.(persistence.CacheStore).Set(key, entry, expCacheAt); err != nil {
+	data, err := c.storeValue(key, entry, entryOptions{}, nil)
+	if err != nil {
+		c.logError(fmt.Sprintf("GenericCache.AddExistingEntry: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		return err
+	}
+	if err := c.Cache.(persistence.CacheStore).Set(key, data, expCacheAt); err != nil {
 		c.logError(fmt.Sprintf("GenericCache.AddExistingEntry: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return err
 	}
@@ -238,6 +319,14 @@ func (c *GenericCache) AddExistingEntry(key string, entry GenericCacheEntry, exp
 
 // Add - adds an entry to the cache
 func (c *GenericCache) Add(key string, data interface{}, exp time.Duration) (err error) {
+	return c.AddWithOptions(key, data, exp)
+}
+
+// AddWithOptions - adds an entry to the cache using the per entry options (see WithEntryEncryption)
+func (c *GenericCache) AddWithOptions(key string, data interface{}, exp time.Duration, opt ...EntryOption) (err error) {
+	defer c.metrics.observe(opAdd, time.Now(), &err)
+	c, span := c.startSpan(opAdd)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Add: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -250,15 +339,32 @@ func (c *GenericCache) Add(key string, data interface{}, exp time.Duration) (err
 	} else {
 		t = c.DefaultExp
 	}
-	if err := c.Cache.(persistence.CacheStore).Add(key, data, t); err != nil {
+	entryOpts := getEntryOpts(opt...)
+	t, entryOpts = entryJitter(data, t, entryOpts)
+	slide, t := c.slidingFor(t, entryOpts)
+	if data, err = c.storeValue(key, data, entryOpts, slide); err != nil {
+		c.logError(fmt.Sprintf("GenericCache.Add: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		return err
+	}
+	t, storeOpts := c.entryExpiry(t, entryOpts)
+	if store, ok := c.Cache.(entryOptionStore); ok {
+		err = store.AddWithOptions(key, data, t, storeOpts...)
+	} else {
+		err = c.Cache.(persistence.CacheStore).Add(key, data, t)
+	}
+	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.Add: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return err
 	}
+	c.bus.Invalidate(key)
 	return nil
 }
 
 // Delete - deletes an entry in the cache
 func (c *GenericCache) Delete(key string) (err error) {
+	defer c.metrics.observe(opDelete, time.Now(), &err)
+	c, span := c.startSpan(opDelete)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Delete: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -270,8 +376,11 @@ func (c *GenericCache) Delete(key string) (err error) {
 			c.logError(fmt.Sprintf("GenericCache.Delete: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 			return err
 		}
+		// other instances may still have it
+		c.bus.Invalidate(key)
 		return persistence.ErrCacheMiss
 	}
+	c.bus.Invalidate(key)
 	return nil
 }
 
@@ -279,6 +388,9 @@ func (c *GenericCache) Delete(key string) (err error) {
 func (c *GenericCache) Exists(key string) (found bool, entry GenericCacheEntry, err error) {
 	if c.ReadCache != nil {
 		c.ReadCache.Logger = c.Logger
+		if c.ctx != nil {
+			return c.ReadCache.WithContext(c.ctx).Exists(key)
+		}
 		return c.ReadCache.Exists(key)
 	}
 	if c.Cache == nil {
@@ -303,6 +415,14 @@ func (c *GenericCache) Exists(key string) (found bool, entry GenericCacheEntry,
 
 // Set - Set a key in the cache (over writting any existing entry)
 func (c *GenericCache) Set(key string, data interface{}, exp time.Duration) (err error) {
+	return c.SetWithOptions(key, data, exp)
+}
+
+// SetWithOptions - Set a key in the cache using the per entry options (see WithEntryEncryption)
+func (c *GenericCache) SetWithOptions(key string, data interface{}, exp time.Duration, opt ...EntryOption) (err error) {
+	defer c.metrics.observe(opSet, time.Now(), &err)
+	c, span := c.startSpan(opSet)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Set: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -315,35 +435,37 @@ func (c *GenericCache) Set(key string, data interface{}, exp time.Duration) (err
 	} else {
 		t = c.DefaultExp
 	}
-	if c.EncryptData {
-		valueType := fmt.Sprintf("%T", data)
-		c.logDebug(fmt.Sprintf("GenericCache.Get: L%v/T%v key == %s and entry type == %s", c.cLevel, c.cType, key, valueType))
-		switch valueType {
-		case "GenericCacheEntry", "cache.GenericCacheEntry":
-			byt := []byte("")
-			b := bytes.NewBuffer(byt)
-			encoder := gob.NewEncoder(b)
-			entryData := data.(GenericCacheEntry).Data
-			if err = encoder.Encode(&entryData); err != nil {
-				return err
-			}
-			encryptedData, err := c.encryptEntry(b.Bytes())
-			if err != nil {
-				c.logError(fmt.Sprintf("GenericCache.Set: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			data = c.NewGenericCacheEntry(encryptedData, exp)
-		}
+	entryOpts := getEntryOpts(opt...)
+	t, entryOpts = entryJitter(data, t, entryOpts)
+	slide, t := c.slidingFor(t, entryOpts)
+	if data, err = c.storeValue(key, data, entryOpts, slide); err != nil {
+		c.logError(fmt.Sprintf("GenericCache.Set: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		return err
+	}
+	t, storeOpts := c.entryExpiry(t, entryOpts)
+	if store, ok := c.Cache.(entryOptionStore); ok {
+		err = store.SetWithOptions(key, data, t, storeOpts...)
+	} else {
+		err = c.Cache.(persistence.CacheStore).Set(key, data, t)
 	}
-	if err := c.Cache.(persistence.CacheStore).Set(key, data, t); err != nil {
+	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.Set: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return err
 	}
+	c.bus.Invalidate(key)
 	return nil
 }
 
 // Replace - Replace an entry in the cache
 func (c *GenericCache) Replace(key string, data interface{}, exp time.Duration) (err error) {
+	return c.ReplaceWithOptions(key, data, exp)
+}
+
+// ReplaceWithOptions - Replace an entry in the cache using the per entry options (see WithEntryEncryption)
+func (c *GenericCache) ReplaceWithOptions(key string, data interface{}, exp time.Duration, opt ...EntryOption) (err error) {
+	defer c.metrics.observe(opReplace, time.Now(), &err)
+	c, span := c.startSpan(opReplace)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Replace: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -362,15 +484,32 @@ func (c *GenericCache) Replace(key string, data interface{}, exp time.Duration)
 	// expiresAt := now + int64(t/time.Second) // convert from nanoseconds
 	// entry := GenericCacheEntry{Data: data, TimeAdded: now, ExpiresAt: expiresAt}
 	// if err := c.Cache.(persistence.CacheStore).Replace(key, entry, t); err != nil {
-	if err := c.Cache.(persistence.CacheStore).Replace(key, data, t); err != nil {
+	entryOpts := getEntryOpts(opt...)
+	t, entryOpts = entryJitter(data, t, entryOpts)
+	slide, t := c.slidingFor(t, entryOpts)
+	if data, err = c.storeValue(key, data, entryOpts, slide); err != nil {
+		c.logError(fmt.Sprintf("GenericCache.Replace: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		return err
+	}
+	t, storeOpts := c.entryExpiry(t, entryOpts)
+	if store, ok := c.Cache.(entryOptionStore); ok {
+		err = store.ReplaceWithOptions(key, data, t, storeOpts...)
+	} else {
+		err = c.Cache.(persistence.CacheStore).Replace(key, data, t)
+	}
+	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.Replace: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return err
 	}
+	c.bus.Invalidate(key)
 	return nil
 }
 
 // Increment - Increment an entry in the cache
 func (c *GenericCache) Increment(key string, n uint64) (newValue uint64, err error) {
+	defer c.metrics.observe(opIncrement, time.Now(), &err)
+	c, span := c.startSpan(opIncrement)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Increment: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -378,16 +517,24 @@ func (c *GenericCache) Increment(key string, n uint64) (newValue uint64, err err
 
 	}
 	c.logDebug(fmt.Sprintf("GenericCache.Increment: L%v/T%v key == %s", c.cLevel, c.cType, key))
-	newValue, err = c.Cache.(persistence.CacheStore).Increment(key, n)
+	if c.sealsEntries() {
+		newValue, err = c.incrementSealed(key, n, false)
+	} else {
+		newValue, err = c.incrementStored(key, n, false)
+	}
 	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.Increment: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return 0, err
 	}
+	c.bus.Invalidate(key)
 	return newValue, nil
 }
 
 // RedisExpireAt - get the TTL of an entry
-func (c *GenericCache) RedisExpireAt(key string, epoc uint64) error {
+func (c *GenericCache) RedisExpireAt(key string, epoc uint64) (err error) {
+	defer c.metrics.observe(opExpireAt, time.Now(), &err)
+	c, span := c.startSpan(opExpireAt)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.RedisExpireAt: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -395,7 +542,7 @@ func (c *GenericCache) RedisExpireAt(key string, epoc uint64) error {
 
 	}
 	c.logDebug(fmt.Sprintf("GenericCache.RedisExpireAt: L%v/T%v key == %s", c.cLevel, c.cType, key))
-	err := c.Cache.(*persistence.RedisStore).ExpireAt(key, epoc)
+	err = c.Cache.(*persistence.RedisStore).ExpireAt(key, epoc)
 	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.RedisExpireAt: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return err
@@ -404,14 +551,17 @@ func (c *GenericCache) RedisExpireAt(key string, epoc uint64) error {
 
 }
 
-func (c *GenericCache) RedisGetExpiresIn(key string) (int64, error) {
+func (c *GenericCache) RedisGetExpiresIn(key string) (ttl int64, err error) {
+	defer c.metrics.observe(opGetExpiresIn, time.Now(), &err)
+	c, span := c.startSpan(opGetExpiresIn)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.RedisGetExpiresIn: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
 		return 0, err
 	}
 	c.logDebug(fmt.Sprintf("GenericCache.RedisExpireAt: L%v/T%v key == %s", c.cLevel, c.cType, key))
-	ttl, err := c.Cache.(*persistence.RedisStore).GetExpiresIn(key)
+	ttl, err = c.Cache.(*persistence.RedisStore).GetExpiresIn(key)
 	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.RedisExpireAt: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return 0, err
@@ -421,6 +571,9 @@ func (c *GenericCache) RedisGetExpiresIn(key string) (int64, error) {
 
 // RedisIncrementAtomic - Increment an entry in the cache
 func (c *GenericCache) RedisIncrementAtomic(key string, n uint64) (newValue uint64, err error) {
+	defer c.metrics.observe(opIncrementAtomic, time.Now(), &err)
+	c, span := c.startSpan(opIncrementAtomic)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.IncrementAtomic: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -433,11 +586,15 @@ func (c *GenericCache) RedisIncrementAtomic(key string, n uint64) (newValue uint
 		c.logError(fmt.Sprintf("GenericCache.IncrementAtomic: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return 0, err
 	}
+	c.bus.Invalidate(key)
 	return newValue, nil
 }
 
 // RedisIncrementCheckSet - Increment an entry in the cache
 func (c *GenericCache) RedisIncrementCheckSet(key string, n uint64) (newValue uint64, err error) {
+	defer c.metrics.observe(opIncrementCheckSet, time.Now(), &err)
+	c, span := c.startSpan(opIncrementCheckSet)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.IncrementCheckSet: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -450,11 +607,15 @@ func (c *GenericCache) RedisIncrementCheckSet(key string, n uint64) (newValue ui
 		c.logError(fmt.Sprintf("GenericCache.IncrementCheckSet: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return 0, err
 	}
+	c.bus.Invalidate(key)
 	return newValue, nil
 }
 
 // Decrement - Decrement an entry in the cache
 func (c *GenericCache) Decrement(key string, n uint64) (newValue uint64, err error) {
+	defer c.metrics.observe(opDecrement, time.Now(), &err)
+	c, span := c.startSpan(opDecrement)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Decrement: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -462,16 +623,24 @@ func (c *GenericCache) Decrement(key string, n uint64) (newValue uint64, err err
 
 	}
 	c.logDebug(fmt.Sprintf("GenericCache.Decrement: L%v/T%v key == %s", c.cLevel, c.cType, key))
-	newValue, err = c.Cache.(persistence.CacheStore).Decrement(key, n)
+	if c.sealsEntries() {
+		newValue, err = c.incrementSealed(key, n, true)
+	} else {
+		newValue, err = c.incrementStored(key, n, true)
+	}
 	if err != nil {
 		c.logError(fmt.Sprintf("GenericCache.Decrement: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
 		return 0, err
 	}
+	c.bus.Invalidate(key)
 	return newValue, nil
 }
 
 // Flush  - Flush all the keys in the cache
-func (c *GenericCache) Flush() error {
+func (c *GenericCache) Flush() (err error) {
+	defer c.metrics.observe(opFlush, time.Now(), &err)
+	c, span := c.startSpan(opFlush)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Flush: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -480,11 +649,28 @@ func (c *GenericCache) Flush() error {
 	}
 	c.logDebug(fmt.Sprintf("GenericCache.Flush: flushing all keys for L%v/T%v key", c.cLevel, c.cType))
 	c.Cache.(persistence.CacheStore).Flush()
+	c.bus.InvalidateAll()
+	return nil
+}
+
+// OnEvict - opt in to eviction callbacks: h is called whenever an entry leaves the cache's InMemoryStore (or
+// ShardedInMemoryStore), with the reason (see InMemoryStore.OnEvict).  The value is the entry as it's stored, so
+// encrypted and signed entries are sealed []byte.  Returns ErrNotSupport for stores without callbacks (e.g. Redis).
+func (c *GenericCache) OnEvict(h EvictionHandler) error {
+	store, ok := c.Cache.(interface{ OnEvict(EvictionHandler) })
+	if !ok {
+		c.logError(fmt.Sprintf("GenericCache.OnEvict: L%v/T%v error - eviction callbacks are not supported by %T", c.cLevel, c.cType, c.Cache))
+		return persistence.ErrNotSupport
+	}
+	store.OnEvict(h)
 	return nil
 }
 
-// Get -  retrieves and entry from the cache
-func (c *GenericCache) Get(key string, value interface{}) error {
+// Get -  retrieves and entry from the cache.  value must be a pointer to the type that was stored (any type works)
+func (c *GenericCache) Get(key string, value interface{}) (err error) {
+	defer c.metrics.observe(opGet, time.Now(), &err)
+	c, span := c.startSpan(opGet)
+	defer span.finish(&err)
 	if c.Cache == nil {
 		err := fmt.Errorf("GenericCache.Get: error - no L%v/T%v cache intialized", c.cLevel, c.cType)
 		c.logError(err.Error())
@@ -492,87 +678,28 @@ func (c *GenericCache) Get(key string, value interface{}) error {
 	}
 	valueType := fmt.Sprintf("%T", value)
 	c.logDebug(fmt.Sprintf("GenericCache.Get: L%v/T%v key == %s and entry type == %s and encryption == %v", c.cLevel, c.cType, key, valueType, c.EncryptData))
-	switch valueType {
-	case "*cache.ResponseCache":
-		entry := value.(*cache.ResponseCache)
-		err := c.Cache.(persistence.CacheStore).Get(key, entry)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
-		}
-		return nil
-	case "*GenericCacheEntry", "*cache.GenericCacheEntry":
-		entry := value.(*GenericCacheEntry)
-		err := c.Cache.(persistence.CacheStore).Get(key, entry)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
-		}
-		if c.EncryptData {
-			decryptedData, err := c.decryptEntry(entry.Data.([]byte))
-			if err != nil {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			// fmt.Println("ENC: ", decryptedData)
-			b := bytes.NewBuffer(decryptedData)
-			decoder := gob.NewDecoder(b)
-			if err = decoder.Decode(&entry.Data); err != nil {
-				return err
-			}
-			// fmt.Println("GET entry.Data: ", entry.Data)
-		}
-		return nil
-	case "*string":
-		entry := value.(*string)
-		err := c.Cache.(persistence.CacheStore).Get(key, entry)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
-		}
-		return nil
-	case "*int", "*int8", "*int16", "*int32", "*int64", "*uint", "*uint8", "*uint16", "*uint32", "*uint64":
-		err := c.Cache.(persistence.CacheStore).Get(key, value)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
-		}
-		return nil
-	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
-		err := c.Cache.(persistence.CacheStore).Get(key, &value)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
-		}
-		return nil
-	case "*float64":
-		entry := value.(*float64)
-		err := c.Cache.(persistence.CacheStore).Get(key, entry)
-		if err != nil {
-			if err.Error() != persistence.ErrCacheMiss.Error() {
-				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
-				return err
-			}
-			return persistence.ErrCacheMiss
+	if c.sealsEntries() {
+		return c.getSealed(key, value)
+	}
+	if handled, err := c.getMixed(key, value); handled {
+		return err
+	}
+	switch v := reflect.ValueOf(value); {
+	case v.Kind() == reflect.Ptr && !v.IsNil():
+	case isNumber(v.Kind()):
+		// a non-pointer number only checks that the entry exists
+		value = &value
+	default:
+		err := fmt.Errorf("GenericCache.Get: L%v/T%v error - not supported type - %s (must be a pointer)", c.cLevel, c.cType, valueType)
+		c.logError(err.Error())
+		return persistence.ErrNotSupport
+	}
+	if err := c.Cache.(persistence.CacheStore).Get(key, value); err != nil {
+		if err.Error() != persistence.ErrCacheMiss.Error() {
+			c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+			return err
 		}
-		return nil
+		return persistence.ErrCacheMiss
 	}
-	err := fmt.Errorf("GenericCache.Get: L%v/T%v error - not supported type - %s", c.cLevel, c.cType, valueType)
-	c.logError(err.Error())
-	return persistence.ErrNotSupport
+	return nil
 }
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the operations of a GenericCache, as they're labelled in its metrics
+const (
+	opGet               = "get"
+	opAdd               = "add"
+	opAddExistingEntry  = "add_existing_entry"
+	opSet               = "set"
+	opReplace           = "replace"
+	opDelete            = "delete"
+	opIncrement         = "increment"
+	opDecrement         = "decrement"
+	opIncrementAtomic   = "increment_atomic"
+	opIncrementCheckSet = "increment_check_set"
+	opExpireAt          = "expire_at"
+	opGetExpiresIn      = "get_expires_in"
+	opFlush             = "flush"
+)
+
+// the stages of an operation that are timed on their own
+const (
+	stageEncrypt     = "encrypt"
+	stageDecrypt     = "decrypt"
+	stageSerialize   = "serialize"
+	stageDeserialize = "deserialize"
+)
+
+var (
+	cacheOps    = []string{opGet, opAdd, opAddExistingEntry, opSet, opReplace, opDelete, opIncrement, opDecrement, opIncrementAtomic, opIncrementCheckSet, opExpireAt, opGetExpiresIn, opFlush}
+	cacheStages = []string{stageEncrypt, stageDecrypt, stageSerialize, stageDeserialize}
+	// lookupOps - the operations on an existing entry, which count hits (the others only count misses and errors)
+	lookupOps = map[string]bool{opGet: true, opDelete: true, opIncrement: true, opDecrement: true, opGetExpiresIn: true}
+	// cacheLatencyBuckets - from 10µs (an L1 hit) to ~2.6s (a struggling L2)
+	cacheLatencyBuckets = prometheus.ExponentialBuckets(0.00001, 4, 10)
+)
+
+// cacheMetrics - the metrics of one GenericCache (resolved from the metric vecs once, so they're cheap to update).
+// A nil *cacheMetrics is a cache without metrics.
+type cacheMetrics struct {
+	ops    map[string]*opMetrics
+	stages map[string]prometheus.Observer
+}
+
+type opMetrics struct {
+	lookup  bool
+	hits    prometheus.Counter
+	misses  prometheus.Counter
+	errors  prometheus.Counter
+	latency prometheus.Observer
+}
+
+// newCacheMetrics - the metrics for a cache of the level and type whose keys have the prefix, registered with r.  The
+// metrics of all the GenericCaches are told apart by their operation, level, type and prefix labels.
+func newCacheMetrics(r *metricsRegistry, cLevel Level, cType Type, prefix []byte) *cacheMetrics {
+	level, typ := fmt.Sprintf("L%d", cLevel), cacheTypeLabel(cType)
+	labels := []string{"operation", "level", "type", "prefix"}
+	m := &cacheMetrics{ops: map[string]*opMetrics{}, stages: map[string]prometheus.Observer{}}
+	for _, op := range cacheOps {
+		m.ops[op] = &opMetrics{
+			lookup:  lookupOps[op],
+			hits:    r.counter("generic_cache_hits_total", "Total number of GenericCache operations that found their entry", labels, op, level, typ, string(prefix)),
+			misses:  r.counter("generic_cache_misses_total", "Total number of GenericCache operations that didn't find their entry", labels, op, level, typ, string(prefix)),
+			errors:  r.counter("generic_cache_errors_total", "Total number of GenericCache operations that failed (misses aren't errors)", labels, op, level, typ, string(prefix)),
+			latency: r.histogram("generic_cache_operation_seconds", "How long GenericCache operations take", cacheLatencyBuckets, labels, op, level, typ, string(prefix)),
+		}
+	}
+	for _, stage := range cacheStages {
+		m.stages[stage] = r.histogram("generic_cache_stage_seconds", "How long GenericCache operations spend encrypting, decrypting, serializing and deserializing entries", cacheLatencyBuckets, []string{"stage", "level", "type", "prefix"}, stage, level, typ, string(prefix))
+	}
+	return m
+}
+
+// cacheTypeLabel - the type label of a cache
+func cacheTypeLabel(cType Type) string {
+	if cType == ReadOnly {
+		return "ReadOnly"
+	}
+	return "Writable"
+}
+
+// observe - count the op's result and observe its latency.  It's deferred with the op's (named) err, so the result
+// is the one the op returns: nil is a hit (for lookups), ErrCacheMiss a miss, and anything else an error.
+func (m *cacheMetrics) observe(op string, start time.Time, err *error) {
+	if m == nil {
+		return
+	}
+	o := m.ops[op]
+	o.latency.Observe(time.Since(start).Seconds())
+	switch {
+	case *err == nil:
+		if o.lookup {
+			o.hits.Inc()
+		}
+	case *err == persistence.ErrCacheMiss:
+		o.misses.Inc()
+	default:
+		o.errors.Inc()
+	}
+}
+
+// observeStage - observe how long a stage (e.g. stageEncrypt) of an op took
+func (m *cacheMetrics) observeStage(stage string, start time.Time) {
+	if m != nil {
+		m.stages[stage].Observe(time.Since(start).Seconds())
+	}
+}
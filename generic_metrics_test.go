@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// observedCount - how many observations a prometheus histogram has
+func observedCount(t *testing.T, o prometheus.Observer) uint64 {
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestGenericCache_OperationMetrics(t *testing.T) {
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("metrics_test_ops"), true, WithOperationMetrics(true))
+	var v int
+	c.Set("a", 1, time.Minute)
+	c.Get("a", &v)
+	c.Get("missing", &v)
+	c.Increment("a", 1)
+	c.Delete("missing")
+	c.Add("a", 1, time.Minute)
+
+	m := c.metrics
+	got := map[string]float64{
+		"get hits":       counterValue(t, m.ops[opGet].hits),
+		"get misses":     counterValue(t, m.ops[opGet].misses),
+		"set hits":       counterValue(t, m.ops[opSet].hits),
+		"increment hits": counterValue(t, m.ops[opIncrement].hits),
+		"delete misses":  counterValue(t, m.ops[opDelete].misses),
+		"add errors":     counterValue(t, m.ops[opAdd].errors),
+		"set errors":     counterValue(t, m.ops[opSet].errors),
+		"get latency":    float64(observedCount(t, m.ops[opGet].latency)),
+		"set latency":    float64(observedCount(t, m.ops[opSet].latency)),
+		"encrypt":        float64(observedCount(t, m.stages[stageEncrypt])),
+		"decrypt":        float64(observedCount(t, m.stages[stageDecrypt])),
+		"serialize":      float64(observedCount(t, m.stages[stageSerialize])),
+		"deserialize":    float64(observedCount(t, m.stages[stageDeserialize])),
+	}
+	expected := map[string]float64{
+		"get hits":       1,
+		"get misses":     1,
+		"set hits":       0, // only lookups count hits
+		"increment hits": 1,
+		"delete misses":  1,
+		"add errors":     1, // "a" exists
+		"set errors":     0,
+		"get latency":    2,
+		"set latency":    1,
+		"encrypt":        3, // set, increment and add
+		"decrypt":        2, // get and increment
+		"serialize":      3,
+		"deserialize":    1, // increment decodes the counter itself
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// the same labels share the registered metrics, and caches without metrics don't have any
+	other := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("metrics_test_ops"), true, WithOperationMetrics(true))
+	if n := counterValue(t, other.metrics.ops[opGet].hits); n != 1 {
+		t.Errorf("expected the caches to share their metrics, got %v", n)
+	}
+	plain := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("metrics_test_ops"), true)
+	if plain.metrics != nil {
+		t.Errorf("expected a cache without WithOperationMetrics not to have metrics")
+	}
+	plain.Get("a", &v)
+}
+
+func TestGenericCache_SerializeMetrics(t *testing.T) {
+	store, _ := NewArenaStore(4, 1<<20, time.Hour, 0, false, "")
+	c := NewCacheWithPool(store, Writable, L2, sharedSecret, defExpSeconds, []byte("metrics_test_serialize"), false, WithOperationMetrics(true))
+	if err := c.Set("plain", "value", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var s string
+	if err := c.Get("plain", &s); err != nil || s != "value" {
+		t.Fatalf("expected value, got %q and %v", s, err)
+	}
+	if n := observedCount(t, c.metrics.stages[stageSerialize]); n != 1 {
+		t.Errorf("expected a plain set to a store of bytes to time its serialization, got %d", n)
+	}
+	if n := observedCount(t, c.metrics.stages[stageDeserialize]); n != 1 {
+		t.Errorf("expected a plain get from a store of bytes to time its deserialization, got %d", n)
+	}
+}
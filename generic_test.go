@@ -1,11 +1,18 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Bose/cache/persistence"
+	"github.com/Bose/go-cache/galapagos_gin/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
 	"github.com/sirupsen/logrus"
 )
 
@@ -168,6 +175,14 @@ var newExpiryLRUInMemoryStore = func(t *testing.T, defaultExpiration time.Durati
 	return c
 }
 
+var newExpiryLRUInMemoryStoreEncrypted = func(t *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+	store, err := NewInMemoryStore(100, time.Second, defCleanupInterval, false, "")
+	if err != nil {
+		panic("can't create inmemory store: " + err.Error())
+	}
+	return NewCacheWithPool(store, Writable, L2, sharedSecret, defExpSeconds, []byte("test"), true)
+}
+
 func TestGenericCache_TypicalGetSet(t *testing.T) {
 	r, err := initTestRedis(t)
 	if err != nil {
@@ -184,6 +199,20 @@ func TestGenericCache_TypicalGetSet(t *testing.T) {
 	// typicalGetSet(t, newFromConnInfoWithoutSentinel) // you can not run this because it writes and we're using sentinel... so you likely won't get master
 }
 
+func TestGenericCache_AnyTypes(t *testing.T) {
+	r, err := initTestRedis(t)
+	if err != nil {
+		t.Fatal("Unable to init test redis: ", err)
+	}
+	defer r.Close()
+
+	anyTypes(t, newGenericStoreRedis)
+	anyTypes(t, newGenericStoreRedisEncrypted)
+	anyTypes(t, newGenericStoreInMemory)
+	anyTypes(t, newExpiryLRUInMemoryStore)
+	anyTypes(t, newExpiryLRUInMemoryStoreEncrypted)
+}
+
 func TestRedisCache_IncrDecr(t *testing.T) {
 	r, err := initTestRedis(t)
 	if err != nil {
@@ -194,6 +223,7 @@ func TestRedisCache_IncrDecr(t *testing.T) {
 	incrDecr(t, newGenericStoreInMemory)
 	incrDecr(t, newGenericStoreRedisEncrypted)
 	incrDecr(t, newExpiryLRUInMemoryStore)
+	incrDecr(t, newExpiryLRUInMemoryStoreEncrypted)
 }
 func TestRedisCache_IncrAtomic(t *testing.T) {
 	r, err := initTestRedis(t)
@@ -277,3 +307,350 @@ func TestRedis_RedisGetExpiresIn(t *testing.T) {
 	defer r.Close()
 	testRedisGetExpiresIn(t, newGenericCache)
 }
+
+func TestGenericCache_EncryptAllTypes(t *testing.T) {
+	c := newExpiryLRUInMemoryStoreEncrypted(t, time.Hour).(*GenericCache)
+	store := c.Cache.(*InMemoryStore)
+
+	// everything handed to the store should be an encrypted []byte
+	stored := func(key string, plain []byte) {
+		var raw []byte
+		if err := store.Get(key, &raw); err != nil {
+			t.Fatalf("expected %s to be stored as []byte: %s", key, err)
+		}
+		if bytes.Contains(raw, plain) {
+			t.Errorf("expected %s to be encrypted", key)
+		}
+	}
+
+	if err := c.Set("string", "plaintext-string", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stored("string", []byte("plaintext-string"))
+	var s string
+	if err := c.Get("string", &s); err != nil || s != "plaintext-string" {
+		t.Errorf("expected plaintext-string, got %s - %v", s, err)
+	}
+
+	if err := c.Add("int", 42, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var i int
+	if err := c.Get("int", &i); err != nil || i != 42 {
+		t.Errorf("expected 42, got %d - %v", i, err)
+	}
+	if err := c.Replace("int", 43, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Get("int", &i); err != nil || i != 43 {
+		t.Errorf("expected 43, got %d - %v", i, err)
+	}
+
+	page := cache.ResponseCache{Status: 200, Header: http.Header{"X-Okay-To-Cache": []string{"true"}}, Data: []byte("plaintext-page")}
+	if err := c.Set("page", page, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stored("page", []byte("plaintext-page"))
+	var gotPage cache.ResponseCache
+	if err := c.Get("page", &gotPage); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotPage.Status != 200 || string(gotPage.Data) != "plaintext-page" || gotPage.Header.Get("x-okay-to-cache") != "true" {
+		t.Errorf("unexpected page: %v", gotPage)
+	}
+
+	entry := c.NewGenericCacheEntry("plaintext-entry", time.Minute)
+	if err := c.Set("entry", entry, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stored("entry", []byte("plaintext-entry"))
+	found, gotEntry, err := c.Exists("entry")
+	if !found || err != nil {
+		t.Fatalf("expected to find the entry: %v", err)
+	}
+	if gotEntry.Data != "plaintext-entry" || gotEntry.TimeAdded != entry.TimeAdded || gotEntry.ExpiresAt != entry.ExpiresAt {
+		t.Errorf("expected %v, got %v", entry, gotEntry)
+	}
+
+	// the type is kept, so decoding into the wrong type is an error
+	if err := c.Get("string", &i); err == nil {
+		t.Errorf("expected an error decoding a string into an int")
+	}
+}
+
+func TestGenericCache_LegacyEncrypted(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	c := NewCacheWithPool(NewRedisStore(pool, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), true)
+	defer c.Close()
+
+	// what the release before sealed entries stored: a GenericCacheEntry whose Data was encrypted, and every other
+	// value as is
+	gob.Register(testStruct{})
+	var entryData interface{} = testStruct{Name: "legacy", Count: 7}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&entryData); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	encrypted, err := encryptByteArray(PKCS7.Padding(b.Bytes(), 16), []byte(sharedSecret))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	legacy := GenericCacheEntry{Data: encrypted, TimeAdded: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	store := c.Cache.(persistence.CacheStore)
+	store.Set("entry", legacy, time.Hour)
+	store.Set("string", "plain", time.Hour)
+
+	var entry GenericCacheEntry
+	if err := c.Get("entry", &entry); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, ok := entry.Data.(testStruct); !ok || got.Name != "legacy" || entry.ExpiresAt != legacy.ExpiresAt {
+		t.Errorf("expected the legacy entry, got %v", entry)
+	}
+	var s string
+	if err := c.Get("string", &s); err != nil || s != "plain" {
+		t.Errorf("expected plain, got %q - %v", s, err)
+	}
+
+	// entries written now are sealed, and still read back
+	if err := c.Set("entry", c.NewGenericCacheEntry(testStruct{Name: "new"}, time.Hour), time.Hour); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Get("entry", &entry); err != nil || entry.Data.(testStruct).Name != "new" {
+		t.Errorf("expected the new entry, got %v - %v", entry, err)
+	}
+}
+
+func TestGenericCache_SealedIncrementAtomic(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	inMemory, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	arena, err := NewArenaStore(2, 1<<16, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stores := map[string]interface{}{"inmemory": inMemory, "arena": arena, "redis": NewRedisStore(pool, time.Hour)}
+	for name, store := range stores {
+		c := NewCacheWithPool(store, Writable, L2, sharedSecret, defExpSeconds, []byte("test"), true)
+		if err := c.Set("counter", 0, time.Hour); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if _, err := c.Increment("counter", 1); err != nil {
+						t.Errorf("%s: unexpected error: %s", name, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		var n int
+		if err := c.Get("counter", &n); err != nil || n != 400 {
+			t.Errorf("%s: expected every increment to count (400), got %d - %v", name, n, err)
+		}
+		if _, err := c.Increment("missing", 1); err != persistence.ErrCacheMiss {
+			t.Errorf("%s: expected ErrCacheMiss, got %v", name, err)
+		}
+		c.Close()
+	}
+	if ttl := m.TTL("counter"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected the counter to keep its TTL, got %v", ttl)
+	}
+}
+
+func TestGenericCache_SignData(t *testing.T) {
+	store, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false, WithSignData(true))
+
+	if err := c.Set("value", "signed-data", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var raw []byte
+	if err := store.Get("value", &raw); err != nil {
+		t.Fatalf("expected the entry to be stored as []byte: %s", err)
+	}
+	if !bytes.Contains(raw, []byte("signed-data")) {
+		t.Errorf("expected a signed entry to not be encrypted")
+	}
+	var s string
+	if err := c.Get("value", &s); err != nil || s != "signed-data" {
+		t.Errorf("expected signed-data, got %s - %v", s, err)
+	}
+
+	// corrupt the entry
+	raw[0] ^= 0xff
+	if err := store.Set("value", raw, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Get("value", &s); err != ErrIntegrityCheckFailed {
+		t.Errorf("expected ErrIntegrityCheckFailed, got %v", err)
+	}
+	if err := store.Get("value", &raw); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the corrupted entry to be evicted, got %v", err)
+	}
+
+	// a validly signed entry copied to another key
+	if err := c.Set("value", "signed-data", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := store.Get("value", &raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := store.Set("copy", raw, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Get("copy", &s); err != ErrIntegrityCheckFailed {
+		t.Errorf("expected ErrIntegrityCheckFailed for a copied entry, got %v", err)
+	}
+
+	// an unsigned entry
+	if err := store.Set("forged", []byte("forged-data"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Get("forged", &s); err != ErrIntegrityCheckFailed {
+		t.Errorf("expected ErrIntegrityCheckFailed for an unsigned entry, got %v", err)
+	}
+
+	// signed and encrypted
+	both := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), true, WithSignData(true))
+	if err := both.Set("both", 42, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, err := both.Increment("both", 1); err != nil || n != 43 {
+		t.Errorf("expected 43, got %d - %v", n, err)
+	}
+	var i int
+	if err := both.Get("both", &i); err != nil || i != 43 {
+		t.Errorf("expected 43, got %d - %v", i, err)
+	}
+}
+
+func TestGenericCache_EntryEncryption(t *testing.T) {
+	r, err := initTestRedis(t)
+	if err != nil {
+		t.Fatal("Unable to init test redis: ", err)
+	}
+	defer r.Close()
+	mixedEntries(t, newGenericCache(t, time.Hour))
+
+	store, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false)
+	mixedEntries(t, c)
+	var raw []byte
+	if err := store.Get("pii", &raw); err != nil || bytes.Contains(raw, []byte("pii-data")) {
+		t.Errorf("expected the forced entry to be encrypted - %v", err)
+	}
+
+	// skip encryption in a cache that encrypts its entries
+	encrypted := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), true)
+	if err := encrypted.SetWithOptions("public", "public-data", time.Minute, WithEntryEncryption(false)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := store.Get("public", &raw); err != nil || !bytes.Contains(raw, []byte("public-data")) {
+		t.Errorf("expected the entry to not be encrypted - %v", err)
+	}
+	var s string
+	if err := encrypted.Get("public", &s); err != nil || s != "public-data" {
+		t.Errorf("expected public-data, got %s - %v", s, err)
+	}
+	if err := encrypted.Get("pii", &s); err != nil || s != "pii-data" {
+		t.Errorf("expected pii-data, got %s - %v", s, err)
+	}
+}
+
+func TestGenericCache_GetReadsStoreOnce(t *testing.T) {
+	store, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, "generic_test_reads_once")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false)
+	c.Set("plain", "plain-data", time.Minute)
+	c.SetWithOptions("pii", "pii-data", time.Minute, WithEntryEncryption(true))
+	var s string
+	if err := c.Get("plain", &s); err != nil || s != "plain-data" {
+		t.Errorf("expected plain-data, got %s - %v", s, err)
+	}
+	if err := c.Get("pii", &s); err != nil || s != "pii-data" {
+		t.Errorf("expected pii-data, got %s - %v", s, err)
+	}
+	if err := c.Get("plain", 0); err != nil {
+		t.Errorf("expected the entry to exist - %v", err)
+	}
+	if err := c.Get("not-there", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if hits := counterValue(t, store.metrics.hits); hits != 3 {
+		t.Errorf("expected 3 hits, got %v", hits)
+	}
+	if misses := counterValue(t, store.metrics.misses); misses != 1 {
+		t.Errorf("expected 1 miss, got %v", misses)
+	}
+}
+
+// mixedEntries - encrypted and plain entries in a cache that doesn't encrypt by default
+func mixedEntries(t *testing.T, c *GenericCache) {
+	if err := c.SetWithOptions("pii", "pii-data", time.Minute, WithEntryEncryption(true)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Set("plain", "plain-data", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var s string
+	if err := c.Get("pii", &s); err != nil || s != "pii-data" {
+		t.Errorf("expected pii-data, got %s - %v", s, err)
+	}
+	if err := c.Get("plain", &s); err != nil || s != "plain-data" {
+		t.Errorf("expected plain-data, got %s - %v", s, err)
+	}
+	if err := c.Get("not-there", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	if err := c.AddWithOptions("pii-counter", 10, time.Minute, WithEntryEncryption(true)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.AddWithOptions("pii-counter", 10, time.Minute, WithEntryEncryption(true)); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if n, err := c.Increment("pii-counter", 5); err != nil || n != 15 {
+		t.Errorf("expected 15, got %d - %v", n, err)
+	}
+	if n, err := c.Decrement("pii-counter", 20); err != nil || n != 0 {
+		t.Errorf("expected 0, got %d - %v", n, err)
+	}
+	if err := c.Set("counter", 10, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, err := c.Increment("counter", 5); err != nil || n != 15 {
+		t.Errorf("expected 15, got %d - %v", n, err)
+	}
+	if err := c.ReplaceWithOptions("counter", 20, time.Minute, WithEntryEncryption(true)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var i int
+	if err := c.Get("counter", &i); err != nil || i != 20 {
+		t.Errorf("expected 20, got %d - %v", i, err)
+	}
+}
@@ -303,3 +303,42 @@ func testRedisGetExpiresIn(t *testing.T, newCache genericCacheFactory) {
 	}
 	t.Logf("ttl == %d", ttl)
 }
+
+type testStruct struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+// Test structs, slices and maps can be stored and retrieved directly
+func anyTypes(t *testing.T, newCache cacheFactory) {
+	c := newCache(t, time.Hour)
+
+	s := testStruct{Name: "foo", Count: 2, Tags: []string{"a", "b"}}
+	if err := c.Set("struct", s, persistence.DEFAULT); err != nil {
+		t.Errorf("Error setting a struct: %s", err)
+	}
+	var gotStruct testStruct
+	if err := c.Get("struct", &gotStruct); err != nil {
+		t.Errorf("Error getting a struct: %s", err)
+	}
+	if gotStruct.Name != s.Name || gotStruct.Count != s.Count || len(gotStruct.Tags) != 2 || gotStruct.Tags[1] != "b" {
+		t.Errorf("Expected %v, got %v", s, gotStruct)
+	}
+
+	if err := c.Set("slice", []int{1, 2, 3}, persistence.DEFAULT); err != nil {
+		t.Errorf("Error setting a slice: %s", err)
+	}
+	var gotSlice []int
+	if err := c.Get("slice", &gotSlice); err != nil || len(gotSlice) != 3 || gotSlice[2] != 3 {
+		t.Errorf("Expected [1 2 3], got %v - %v", gotSlice, err)
+	}
+
+	if err := c.Set("map", map[string]int{"a": 1}, persistence.DEFAULT); err != nil {
+		t.Errorf("Error setting a map: %s", err)
+	}
+	var gotMap map[string]int
+	if err := c.Get("map", &gotMap); err != nil || gotMap["a"] != 1 {
+		t.Errorf("Expected map[a:1], got %v - %v", gotMap, err)
+	}
+}
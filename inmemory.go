@@ -1,13 +1,17 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"reflect"
 	"runtime"
+	"sync"
 	"time"
 
-	"github.com/Bose/go-cache/galapagos_gin/cache"
 	"github.com/Bose/cache/persistence"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 )
 
 // InMemoryStore - an in memory LRU store with expiry
@@ -15,10 +19,137 @@ type InMemoryStore struct {
 	*inMemoryStore
 }
 
+// lockStripes - the number of striped key locks used to make conditional operations atomic
+const lockStripes = 256
+
 type inMemoryStore struct {
-	lru        *lru.ARCCache
+	policy     EvictionPolicy
 	DefaultExp time.Duration
 	janitor    *janitor
+	// locks - striped per key locks held for read-modify-write ops (Add, Replace, Increment, etc), so they're atomic
+	// with respect to each other and to Set/Delete of the same key
+	locks [lockStripes]sync.Mutex
+	// book - held while an entry's policy entry, expiry and cost are changed together, so an entry the policy evicts
+	// while another key is added (see evicted) can't have its expiry or cost recorded after it's gone
+	book sync.Mutex
+	// budget - the max bytes budget (nil when the store is only bounded by maxEntries)
+	budget *byteBudget
+	// expiry - the expiring entries by ExpiresAt, for the janitor
+	expiry *expiryIndex[string]
+	// maxExpiredPerTick - the max number of expired entries removed by a DeleteExpired
+	maxExpiredPerTick int
+	// evictions - the OnEvict handlers
+	evictions evictions
+	// snapshotFile - where SaveSnapshot writes the store (see WithSnapshotFile)
+	snapshotFile string
+	// metrics - the store's counters (nil when the store was created without metrics)
+	metrics *storeMetrics
+	// registry - where the store's metrics are registered (nil when the store was created without metrics)
+	registry *metricsRegistry
+	// copyOnRead - deep copy values when they're stored and read (see WithCopyOnRead)
+	copyOnRead bool
+	// jitter - applied to the expiry of entries that are set (see WithExpiryJitter)
+	jitter expiryJitter
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// lockKey - lock the stripe for key and return it (so it can be unlocked)
+func (c *inMemoryStore) lockKey(key string) *sync.Mutex {
+	l := c.keyLock(key)
+	l.Lock()
+	return l
+}
+
+// keyLock - the stripe for key
+func (c *inMemoryStore) keyLock(key string) *sync.Mutex {
+	return &c.locks[fnv32a(key)%lockStripes]
+}
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// fnv32a - FNV-1a of the key (without the allocations of hash/fnv)
+func fnv32a(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// fnv32 - FNV-1 of the key
+func fnv32(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h *= fnvPrime32
+		h ^= uint32(key[i])
+	}
+	return h
+}
+
+// evicted - the policy evicted the entry to make room (it's called while the policy is locked, by a put holding
+// c.book)
+func (c *inMemoryStore) evicted(key interface{}, value interface{}) {
+	c.expiry.remove(key.(string))
+	c.metrics.evicted(EvictReasonCapacity, 1)
+	if c.evictions.enabled() {
+		c.evictions.queue(key.(string), value.(GenericCacheEntry).Data, EvictReasonCapacity)
+	}
+	if c.budget != nil {
+		c.budget.forget(key.(string))
+	}
+}
+
+// remove - remove the entry for key (the caller must hold the key's lock)
+func (c *inMemoryStore) remove(key string, reason EvictReason) {
+	c.evicting(key, reason)
+	c.metrics.evicted(reason, 1)
+	c.book.Lock()
+	defer c.book.Unlock()
+	c.policy.Remove(key)
+	c.expiry.remove(key)
+	if c.budget != nil {
+		c.budget.forget(key)
+	}
+}
+
+// live - get the unexpired entry for key (the caller must hold the key's lock)
+func (c *inMemoryStore) live(key string) (GenericCacheEntry, bool) {
+	v, ok := c.policy.Get(key)
+	if !ok {
+		return GenericCacheEntry{}, false
+	}
+	entry := v.(GenericCacheEntry)
+	if entry.Expired() {
+		c.remove(key, EvictReasonExpired)
+		c.metrics.expired(expiredOnRead)
+		return GenericCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// removeExpired - remove the entry for key if it's still expired once the key is locked (it may have been Set since
+// it was read)
+func (c *inMemoryStore) removeExpired(key string, source string) {
+	defer c.lockKey(key).Unlock()
+	if v, ok := c.policy.Peek(key); ok {
+		if entry := v.(GenericCacheEntry); entry.Expired() {
+			c.remove(key, EvictReasonExpired)
+			c.metrics.expired(source)
+		} else if source == expiredByJanitor {
+			// popped at the very start of its ExpiresAt second, so it has to stay indexed
+			c.book.Lock()
+			if c.policy.Contains(key) {
+				c.expiry.set(key, entry.ExpiresAt)
+			}
+			c.book.Unlock()
+		}
+	}
 }
 
 // NewGenericCacheEntry - create a new in memory cache entry
@@ -31,35 +162,72 @@ func (c *InMemoryStore) NewGenericCacheEntry(data interface{}, exp time.Duration
 		t = c.DefaultExp
 	}
 	now := time.Now().Unix()
-	expiresAt := now + int64(t/time.Second) // convert from nanoseconds
+	expiresAt := now + int64(c.jitter.apply(t)/time.Second) // convert from nanoseconds
 
 	entry := GenericCacheEntry{Data: data, TimeAdded: now, ExpiresAt: expiresAt}
 	return entry, nil
 }
 
-// NewInMemoryStore - create a new in memory cache
-func NewInMemoryStore(maxEntries int, defaultExpiration, cleanupInterval time.Duration, createMetric bool, metricLabel string) (*InMemoryStore, error) {
-	lru, err := lru.NewARC(maxEntries)
+// NewInMemoryStore - create a new in memory cache.  Supported options: WithEvictionPolicy, WithMaxBytes,
+// WithCostFunc, WithMaxExpiredPerTick, WithSnapshotFile, WithSnapshotLogger, WithCopyOnRead, WithExpiryJitter,
+// WithExpiryJitterPercent, and WithRegisterer, WithMetricNamespace and WithConstLabels for its metrics.
+func NewInMemoryStore(maxEntries int, defaultExpiration, cleanupInterval time.Duration, createMetric bool, metricLabel string, opt ...Option) (*InMemoryStore, error) {
+	opts := GetOpts(opt...)
+	c := &inMemoryStore{
+		DefaultExp:        defaultExpiration,
+		expiry:            newExpiryIndex[string](),
+		maxExpiredPerTick: opts[optionWithMaxExpiredPerTick].(int),
+		snapshotFile:      opts[optionWithSnapshotFile].(string),
+		copyOnRead:        opts[optionWithCopyOnRead].(bool),
+		jitter:            newExpiryJitter(opts),
+	}
+	if maxBytes := opts[optionWithMaxBytes].(int64); maxBytes > 0 {
+		cost, _ := opts[optionWithCostFunc].(CostFunc)
+		c.budget = newByteBudget(maxBytes, cost)
+	}
+	newPolicy, _ := opts[optionWithEvictionPolicy].(PolicyFactory)
+	if newPolicy == nil {
+		newPolicy = NewARCPolicy
+	}
+	policy, err := newPolicy(maxEntries, c.evicted)
 	if err != nil {
 		return nil, err
 	}
-	c := &inMemoryStore{
-		lru:        lru,
-		DefaultExp: defaultExpiration,
-	}
+	c.policy = policy
 
 	if createMetric {
+		c.registry = newMetricsRegistry(opts)
+		c.metrics = newStoreMetrics(c.registry, storeMetricName(metricLabel))
 		label := "inmemory_cache_total_items_cnt"
 		if len(metricLabel) != 0 {
 			label = metricLabel
 		}
 		// setup metrics
-		initGaugeWithFunc(
+		if err := c.registry.gaugeFunc(
 			func() float64 {
-				return float64(lru.Len())
+				return float64(policy.Len())
 			},
 			label,
-			fmt.Sprintf("Total count the number of items in the in-memory cache for %s", label))
+			fmt.Sprintf("Total count the number of items in the in-memory cache for %s", label)); err != nil {
+			c.registry.unregister()
+			return nil, err
+		}
+		if c.budget != nil {
+			budget := c.budget
+			bytesLabel := "inmemory_cache_total_bytes"
+			if len(metricLabel) != 0 {
+				bytesLabel = metricLabel + "_bytes"
+			}
+			if err := c.registry.gaugeFunc(
+				func() float64 {
+					return float64(budget.Total())
+				},
+				bytesLabel,
+				fmt.Sprintf("Total cost in bytes of the items in the in-memory cache for %s", label)); err != nil {
+				c.registry.unregister()
+				return nil, err
+			}
+		}
 	}
 
 	// This trick ensures that the janitor goroutine (which--granted it
@@ -68,96 +236,246 @@ func NewInMemoryStore(maxEntries int, defaultExpiration, cleanupInterval time.Du
 	// garbage collected, the finalizer stops the janitor goroutine, after
 	// which c can be collected.
 	C := &InMemoryStore{c}
+	if c.snapshotFile != "" {
+		restoreSnapshotFile(c.snapshotFile, C.Restore, opts[optionWithSnapshotLogger].(*logrus.Entry))
+	}
 	if cleanupInterval > 0 {
-		runJanitor(c, cleanupInterval)
+		c.janitor = runJanitor(c, cleanupInterval, c.metrics.janitorObserver())
 		runtime.SetFinalizer(C, stopJanitor)
 	}
 	return C, nil
 }
 
-// Get - Get an entry
+// Get - Get an entry.  value must be a non-nil pointer and the entry's data must be assignable (or, for numbers,
+// losslessly convertible) to what it points at, otherwise a *TypeMismatchError is returned.  A *GenericCacheEntry
+// gets the whole entry.
 func (c *InMemoryStore) Get(key string, value interface{}) error {
-	if val, ok := c.lru.Get(key); ok {
+	if val, ok := c.policy.Get(key); ok {
 		entry := val.(GenericCacheEntry)
 		if entry.Expired() {
-			c.lru.Remove(key)
+			c.removeExpired(key, expiredOnRead)
+			c.evictions.dispatch()
+			c.metrics.miss()
 			return persistence.ErrCacheMiss
 		}
-		valueType := fmt.Sprintf("%T", value)
-		switch valueType {
-		case "*string":
-			*value.(*string) = entry.Data.(string)
-			return nil
-		case "*int":
-			*value.(*int) = entry.Data.(int)
-			return nil
-		case "*cache.GenericCacheEntry":
-			*value.(*GenericCacheEntry) = entry
-			return nil
-		case "*cache.ResponseCache":
-			*value.(*cache.ResponseCache) = entry.Data.(cache.ResponseCache)
+		c.metrics.hit()
+		if c.copyOnRead {
+			entry.Data = deepCopy(entry.Data)
+		}
+		if e, ok := value.(*GenericCacheEntry); ok {
+			*e = entry
 			return nil
 		}
-		return persistence.ErrNotSupport
+		return assignValue(key, entry.Data, value)
 	}
+	c.metrics.miss()
 	return persistence.ErrCacheMiss
 }
-func (c *InMemoryStore) doAddSet(key string, value interface{}, exp time.Duration) error {
+
+// TypeMismatchError - the cached value can't be stored in the Get target
+type TypeMismatchError struct {
+	Key    string
+	Stored string
+	Target string
+}
+
+// Error - the error message
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("cache: type mismatch for key %s - stored %s can't be assigned to %s", e.Key, e.Stored, e.Target)
+}
+
+// assignValue - set what target points at to stored, using reflection so any type can be retrieved
+func assignValue(key string, stored interface{}, target interface{}) error {
+	tv := reflect.ValueOf(target)
+	if tv.Kind() != reflect.Ptr || tv.IsNil() {
+		return &TypeMismatchError{Key: key, Stored: fmt.Sprintf("%T", stored), Target: fmt.Sprintf("%T (not a non-nil pointer)", target)}
+	}
+	dst := tv.Elem()
+	if stored == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(stored)
+	switch {
+	case sv.Type().AssignableTo(dst.Type()):
+		dst.Set(sv)
+		return nil
+	case sv.Kind() == reflect.Ptr && !sv.IsNil() && sv.Elem().Type().AssignableTo(dst.Type()):
+		dst.Set(sv.Elem())
+		return nil
+	case isNumber(sv.Kind()) && isNumber(dst.Kind()):
+		if convertNumber(sv, dst) {
+			return nil
+		}
+	}
+	return &TypeMismatchError{Key: key, Stored: sv.Type().String(), Target: dst.Type().String()}
+}
+
+// convertNumber - set dst to the number sv, when it's lossless: it fits in dst's type, and it's not a float with a
+// fractional part going into an integer
+func convertNumber(sv, dst reflect.Value) bool {
+	switch dk := dst.Kind(); {
+	case isSigned(sv.Kind()):
+		i := sv.Int()
+		switch {
+		case isSigned(dk):
+			return setIf(!dst.OverflowInt(i), dst, sv)
+		case isFloat(dk):
+			return setIf(!dst.OverflowFloat(float64(i)), dst, sv)
+		default:
+			return setIf(i >= 0 && !dst.OverflowUint(uint64(i)), dst, sv)
+		}
+	case isFloat(sv.Kind()):
+		f := sv.Float()
+		switch {
+		case isFloat(dk):
+			return setIf(!dst.OverflowFloat(f), dst, sv)
+		case f != math.Trunc(f):
+			return false
+		case isSigned(dk):
+			return setIf(f >= math.MinInt64 && f < math.MaxInt64 && !dst.OverflowInt(int64(f)), dst, sv)
+		default:
+			return setIf(f >= 0 && f < math.MaxUint64 && !dst.OverflowUint(uint64(f)), dst, sv)
+		}
+	default:
+		u := sv.Uint()
+		switch {
+		case isSigned(dk):
+			return setIf(u <= math.MaxInt64 && !dst.OverflowInt(int64(u)), dst, sv)
+		case isFloat(dk):
+			return setIf(!dst.OverflowFloat(float64(u)), dst, sv)
+		default:
+			return setIf(!dst.OverflowUint(u), dst, sv)
+		}
+	}
+}
+
+// setIf - set dst to sv converted to dst's type, if ok
+func setIf(ok bool, dst, sv reflect.Value) bool {
+	if ok {
+		dst.Set(sv.Convert(dst.Type()))
+	}
+	return ok
+}
+
+func isSigned(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func (c *InMemoryStore) doAddSet(key string, value interface{}, exp time.Duration, opts entryOptions) error {
 	valueType := fmt.Sprintf("%T", value)
 	now := time.Now().Unix()
 	var expiresAt int64
 	if exp == persistence.FOREVER {
 		expiresAt = 0
-	} else if exp != 0 {
-		expiresAt = now + int64(exp/time.Second)
 	} else {
-		expiresAt = now + int64(c.DefaultExp/time.Second)
+		if exp == 0 {
+			exp = c.DefaultExp
+		}
+		if !opts.exactExpiry {
+			exp = c.jitter.apply(exp)
+		}
+		expiresAt = now + int64(exp/time.Second)
 	}
-	if valueType != "cache.GenericCacheEntry" {
-		e := GenericCacheEntry{Data: value, ExpiresAt: expiresAt, TimeAdded: now}
-		c.lru.Add(key, e)
-		return nil
+	e := GenericCacheEntry{Data: value, ExpiresAt: expiresAt, TimeAdded: now}
+	if valueType == "cache.GenericCacheEntry" {
+		e.Data = value.(GenericCacheEntry).Data
 	}
-	e := GenericCacheEntry{Data: value.(GenericCacheEntry).Data, ExpiresAt: expiresAt, TimeAdded: now}
-	c.lru.Add(key, e)
+	if c.copyOnRead {
+		e.Data = deepCopy(e.Data)
+	}
+	if err := c.put(key, e); err != nil {
+		return err
+	}
+	c.metrics.set()
 	return nil
 }
 
 // Keys - get all the keys
 func (c *InMemoryStore) Keys() []interface{} {
-	return c.lru.Keys()
+	return c.policy.Keys()
 }
 
 // Len - get the current count of entries in the cache
 func (c *InMemoryStore) Len() int {
-	return c.lru.Len()
+	return c.policy.Len()
 }
 
 // Set - set an entry
 func (c *InMemoryStore) Set(key string, value interface{}, exp time.Duration) error {
-	return c.doAddSet(key, value, exp)
+	return c.SetWithOptions(key, value, exp)
+}
+
+// SetWithOptions - set an entry using the per entry options (only WithExactExpiry applies to the store)
+func (c *InMemoryStore) SetWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	c.replacing(key)
+	return c.doAddSet(key, value, exp, getEntryOpts(opt...))
 }
 
 // Add - add an entry
 func (c *InMemoryStore) Add(key string, value interface{}, exp time.Duration) error {
-	if _, ok := c.lru.Get(key); ok {
+	return c.AddWithOptions(key, value, exp)
+}
+
+// AddWithOptions - add an entry using the per entry options (only WithExactExpiry applies to the store)
+func (c *InMemoryStore) AddWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	if _, ok := c.live(key); ok {
 		return persistence.ErrNotStored
 	}
-	return c.doAddSet(key, value, exp)
+	return c.doAddSet(key, value, exp, getEntryOpts(opt...))
 }
 
 // Replace - replace an entry
 func (c *InMemoryStore) Replace(key string, value interface{}, exp time.Duration) error {
-	if _, ok := c.lru.Get(key); ok {
-		return c.doAddSet(key, value, exp)
+	return c.ReplaceWithOptions(key, value, exp)
+}
+
+// ReplaceWithOptions - replace an entry using the per entry options (only WithExactExpiry applies to the store)
+func (c *InMemoryStore) ReplaceWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	if _, ok := c.live(key); ok {
+		c.replacing(key)
+		return c.doAddSet(key, value, exp, getEntryOpts(opt...))
 	}
 	return persistence.ErrNotStored
 }
 
 // Update - update an entry
 func (c *InMemoryStore) Update(key string, entry GenericCacheEntry) error {
-	if _, ok := c.lru.Get(key); ok {
-		c.lru.Add(key, entry)
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	if _, ok := c.live(key); ok {
+		c.replacing(key)
+		if c.copyOnRead {
+			entry.Data = deepCopy(entry.Data)
+		}
+		if err := c.put(key, entry); err != nil {
+			return err
+		}
+		c.metrics.set()
 		return nil
 	}
 	return persistence.ErrNotStored
@@ -165,8 +483,10 @@ func (c *InMemoryStore) Update(key string, entry GenericCacheEntry) error {
 
 // Delete - delete an entry
 func (c *InMemoryStore) Delete(key string) error {
-	if _, ok := c.lru.Get(key); ok {
-		c.lru.Remove(key)
+	defer c.evictions.dispatch()
+	defer c.lockKey(key).Unlock()
+	if _, ok := c.live(key); ok {
+		c.remove(key, EvictReasonDeleted)
 		return nil
 	}
 	return persistence.ErrCacheMiss
@@ -174,87 +494,230 @@ func (c *InMemoryStore) Delete(key string) error {
 
 // Increment (see CacheStore interface)
 func (c *InMemoryStore) Increment(key string, n uint64) (uint64, error) {
-	v, ok := c.lru.Get(key)
+	return c.addToCounter(key, n, false)
+}
+
+// Decrement (see CacheStore interface)
+func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.addToCounter(key, n, true)
+}
+
+// addToCounter - atomically Increment/Decrement the counter for key
+func (c *InMemoryStore) addToCounter(key string, n uint64, decrement bool) (uint64, error) {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	entry, ok := c.live(key)
 	if !ok {
 		return 0, persistence.ErrCacheMiss
 	}
-	entry := v.(GenericCacheEntry)
-	valueType := fmt.Sprintf("%T", entry.Data)
-	switch valueType {
-	case "int":
-		entry.Data = entry.Data.(int) + int(n)
-		c.lru.Add(key, entry)
-		return uint64(entry.Data.(int)), nil
+	data, newValue, err := addToInteger(entry.Data, n, decrement)
+	if err != nil {
+		return 0, err
+	}
+	entry.Data = data
+	if err := c.put(key, entry); err != nil {
+		return 0, err
 	}
-	return 0, persistence.ErrNotSupport
+	return newValue, nil
 }
 
-// Decrement (see CacheStore interface)
-func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
-	v, ok := c.lru.Get(key)
+// updateSealed - replace the sealed entry for key with update's result while holding the key's lock, keeping its
+// expiry (see sealedUpdater)
+func (c *InMemoryStore) updateSealed(key string, update func(sealed []byte) ([]byte, error)) error {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	defer c.lockKey(key).Unlock()
+	entry, ok := c.live(key)
 	if !ok {
-		return 0, persistence.ErrCacheMiss
+		return persistence.ErrCacheMiss
 	}
-	entry := v.(GenericCacheEntry)
-	valueType := fmt.Sprintf("%T", entry.Data)
-	switch valueType {
-	case "int":
-		if int(n) > entry.Data.(int) {
-			entry.Data = 0
-		} else {
-			entry.Data = entry.Data.(int) - int(n)
+	sealed, ok := entry.Data.([]byte)
+	if !ok {
+		return persistence.ErrNotSupport
+	}
+	updated, err := update(sealed)
+	if err != nil {
+		return err
+	}
+	entry.Data = updated
+	return c.put(key, entry)
+}
+
+// addToInteger - add/subtract n to any integer type, keeping its type.  Increments wrap around like the type does
+// and decrements are capped at 0.  int64 and uint64 are handled natively, the other integer types via reflection.
+func addToInteger(data interface{}, n uint64, decrement bool) (interface{}, uint64, error) {
+	switch v := data.(type) {
+	case int64:
+		switch {
+		case !decrement:
+			v += int64(n)
+		case n > uint64(v) || v < 0:
+			v = 0
+		default:
+			v -= int64(n)
 		}
-		c.lru.Add(key, entry)
-		return uint64(entry.Data.(int)), nil
+		return v, uint64(v), nil
+	case uint64:
+		switch {
+		case !decrement:
+			v += n
+		case n > v:
+			v = 0
+		default:
+			v -= n
+		}
+		return v, v, nil
 	}
-	return 0, persistence.ErrNotSupport
+	rv := reflect.ValueOf(data)
+	result := reflect.New(rv.Type()).Elem()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		switch {
+		case !decrement:
+			i += int64(n)
+		case n > uint64(i) || i < 0:
+			i = 0
+		default:
+			i -= int64(n)
+		}
+		result.SetInt(i)
+		return result.Interface(), uint64(result.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		switch {
+		case !decrement:
+			u += n
+		case n > u:
+			u = 0
+		default:
+			u -= n
+		}
+		result.SetUint(u)
+		return result.Interface(), result.Uint(), nil
+	}
+	return nil, 0, persistence.ErrNotSupport
 }
 
 // Flush (see CacheStore interface)
 func (c *InMemoryStore) Flush() error {
-	c.lru.Purge()
+	if c.evictions.enabled() {
+		for _, k := range c.policy.Keys() {
+			if v, ok := c.policy.Peek(k); ok {
+				c.evictions.queue(k.(string), v.(GenericCacheEntry).Data, EvictReasonFlushed)
+			}
+		}
+		defer c.evictions.dispatch()
+	}
+	c.metrics.evicted(EvictReasonFlushed, c.policy.Len())
+	c.book.Lock()
+	defer c.book.Unlock()
+	c.policy.Purge()
+	c.expiry.reset()
+	if c.budget != nil {
+		c.budget.reset()
+	}
 	return nil
 }
 
-// DeleteExpired - Delete all expired items from the cache.
+// DeleteExpired - Delete the expired items from the cache (at most WithMaxExpiredPerTick of them, the ones that
+// expired first).  Only entries that are due are visited, and they're not promoted by the eviction policy.
 func (c *inMemoryStore) DeleteExpired() {
-	keys := c.lru.Keys()
-	for _, key := range keys {
-		if entry, ok := c.lru.Get(key); ok {
-			e := entry.(GenericCacheEntry)
-			if e.Expired() {
-				c.lru.Remove(key)
-			}
-		}
+	for _, key := range c.expiry.popDue(time.Now().Unix(), c.maxExpiredPerTick) {
+		c.removeExpired(key, expiredByJanitor)
 	}
+	c.evictions.dispatch()
 }
 
 type janitor struct {
 	Interval time.Duration
-	stop     chan bool
+	observe  prometheus.Observer
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// expirer - a store the janitor can delete expired entries from
+type expirer interface {
+	DeleteExpired()
 }
 
-func (j *janitor) Run(c *inMemoryStore) {
-	j.stop = make(chan bool)
-	tick := time.Tick(j.Interval)
+func (j *janitor) Run(c expirer) {
+	defer close(j.done)
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-tick:
+		case <-ticker.C:
+			start := time.Now()
 			c.DeleteExpired()
+			if j.observe != nil {
+				j.observe.Observe(time.Since(start).Seconds())
+			}
 		case <-j.stop:
 			return
 		}
 	}
 }
 
+// signal - tell the janitor to stop, without waiting for it
+func (j *janitor) signal() {
+	j.stopOnce.Do(func() { close(j.stop) })
+}
+
+// Stop - stop the janitor and wait for it to finish (or for ctx to be done)
+func (j *janitor) Stop(ctx context.Context) error {
+	j.signal()
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func stopJanitor(c *InMemoryStore) {
-	c.janitor.stop <- true
+	c.janitor.signal()
 }
 
-func runJanitor(c *inMemoryStore, ci time.Duration) {
+// runJanitor - start a janitor that runs DeleteExpired every ci, observing its runtime (observe may be nil)
+func runJanitor(c expirer, ci time.Duration, observe prometheus.Observer) *janitor {
 	j := &janitor{
 		Interval: ci,
+		observe:  observe,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
 	}
-	c.janitor = j
 	go j.Run(c)
+	return j
+}
+
+// Close - Shutdown, waiting at most defaultCloseTimeout
+func (c *InMemoryStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown - stop the janitor, dispatch pending OnEvict callbacks, unregister the store's metrics and save the
+// WithSnapshotFile snapshot.  It's idempotent (later calls return the first call's error), and it returns ctx's error
+// if ctx is done before the janitor stops.  The store can still be used after it's shut down, but expired entries are
+// only removed when they're read.
+func (c *InMemoryStore) Shutdown(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		var err error
+		if c.janitor != nil {
+			err = c.janitor.Stop(ctx)
+		}
+		c.evictions.dispatch()
+		c.registry.unregister()
+		if c.snapshotFile != "" {
+			if snapErr := c.SaveSnapshot(); snapErr != nil && err == nil {
+				err = snapErr
+			}
+		}
+		c.closeErr = err
+	})
+	return c.closeErr
 }
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"fmt"
+	"math/rand"
+	"runtime"
 	"testing"
 	"time"
 
+	"github.com/Bose/cache/persistence"
 	"github.com/sirupsen/logrus"
 )
 
@@ -57,3 +61,99 @@ func newBenchGenericStoreInMemory(defaultExpiration time.Duration, encryptEntrie
 	c.Logger = logger
 	return c
 }
+
+// benchmarkPolicyHitRatio - a zipf distributed workload (a few keys are very popular) over 100x more keys than fit
+func benchmarkPolicyHitRatio(b *testing.B, newPolicy PolicyFactory) {
+	const size = 1000
+	store, err := NewInMemoryStore(size, time.Hour, 0, false, "", WithEvictionPolicy(newPolicy))
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := make([]string, size*100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(len(keys)-1))
+	hits := 0
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		key := keys[zipf.Uint64()]
+		var v int
+		if err := store.Get(key, &v); err == nil {
+			hits++
+			continue
+		}
+		store.Set(key, n, persistence.DEFAULT)
+	}
+	b.ReportMetric(float64(hits)/float64(b.N)*100, "hit%")
+}
+
+func BenchmarkPolicyHitRatioLRU(b *testing.B)     { benchmarkPolicyHitRatio(b, NewLRUPolicy) }
+func BenchmarkPolicyHitRatioARC(b *testing.B)     { benchmarkPolicyHitRatio(b, NewARCPolicy) }
+func BenchmarkPolicyHitRatioLFU(b *testing.B)     { benchmarkPolicyHitRatio(b, NewLFUPolicy) }
+func BenchmarkPolicyHitRatioTinyLFU(b *testing.B) { benchmarkPolicyHitRatio(b, NewTinyLFUPolicy) }
+
+// benchmarkParallelGetSet - concurrent Gets and Sets over 10000 keys (which all fit, even when the shards are uneven)
+func benchmarkParallelGetSet(b *testing.B, store persistence.CacheStore) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			var v int
+			if err := store.Get(key, &v); err != nil {
+				store.Set(key, i, persistence.DEFAULT)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelGetSetInMemory(b *testing.B) {
+	store, err := NewInMemoryStore(20000, time.Hour, 0, false, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParallelGetSet(b, store)
+}
+
+func BenchmarkParallelGetSetShardedInMemory(b *testing.B) {
+	store, err := NewShardedInMemoryStore(runtime.GOMAXPROCS(0)*4, 20000, time.Hour, 0, false, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParallelGetSet(b, store)
+}
+
+// benchCopyValue - a typical cached struct with a slice and a map
+type benchCopyValue struct {
+	ID    int
+	Name  string
+	Tags  []string
+	Attrs map[string]string
+}
+
+// benchmarkInMemoryGet - Gets of a struct value, to measure the allocations of WithCopyOnRead
+func benchmarkInMemoryGet(b *testing.B, opt ...Option) {
+	store, err := NewInMemoryStore(100, time.Hour, 0, false, "", opt...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := benchCopyValue{ID: 1, Name: "name", Tags: []string{"a", "b", "c"}, Attrs: map[string]string{"k1": "v1", "k2": "v2"}}
+	store.Set("key", value, time.Hour)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var got benchCopyValue
+		if err := store.Get("key", &got); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInMemoryGet(b *testing.B)           { benchmarkInMemoryGet(b) }
+func BenchmarkInMemoryGetCopyOnRead(b *testing.B) { benchmarkInMemoryGet(b, WithCopyOnRead(true)) }
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Bose/cache/utils"
+)
+
+// ErrEntryTooLarge - the entry's cost is more than the store's max bytes, so it can never fit
+var ErrEntryTooLarge = errors.New("cache: entry is larger than the max bytes")
+
+// CostFunc - returns the cost in bytes of storing value for key in an InMemoryStore with WithMaxBytes
+type CostFunc func(key string, value interface{}) int64
+
+const (
+	// budgetLowWaterPercent - an eviction pass evicts down to this percent of the max bytes, so passes don't happen
+	// on every Set once the store is full
+	budgetLowWaterPercent = 90
+	// unserializableCost - the estimated cost of values that can't be serialized
+	unserializableCost = 64
+)
+
+// byteBudget - tracks the cost of each entry in an InMemoryStore.  Updates for a key are made while holding the
+// key's lock.
+type byteBudget struct {
+	maxBytes int64
+	lowWater int64
+	cost     CostFunc
+
+	mu    sync.Mutex
+	costs map[string]int64
+	total int64
+
+	// evicting - held during an eviction pass, so there's only one at a time
+	evicting sync.Mutex
+}
+
+func newByteBudget(maxBytes int64, cost CostFunc) *byteBudget {
+	if cost == nil {
+		cost = estimateCost
+	}
+	return &byteBudget{
+		maxBytes: maxBytes,
+		lowWater: maxBytes * budgetLowWaterPercent / 100,
+		cost:     cost,
+		costs:    map[string]int64{},
+	}
+}
+
+// estimateCost - the default CostFunc: the len of the key plus the serialized value
+func estimateCost(key string, value interface{}) int64 {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return int64(len(key)) + unserializableCost
+	}
+	return int64(len(key) + len(b))
+}
+
+// Total - the current total cost of the entries
+func (b *byteBudget) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+func (b *byteBudget) record(key string, cost int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += cost - b.costs[key]
+	b.costs[key] = cost
+}
+
+func (b *byteBudget) forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total -= b.costs[key]
+	delete(b.costs, key)
+}
+
+func (b *byteBudget) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.costs = map[string]int64{}
+	b.total = 0
+}
+
+func (b *byteBudget) trackedKeys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.costs))
+	for k := range b.costs {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// put - add the entry to the lru, recording its cost and when it expires (the caller must hold the key's lock).  An
+// entry that's over the budget isn't stored, and the entry it was replacing is dropped (Set/Replace/Update have
+// already reported it as replaced), so the store doesn't keep serving the value the caller tried to overwrite.
+func (c *inMemoryStore) put(key string, entry GenericCacheEntry) error {
+	var cost int64
+	if c.budget != nil {
+		cost = c.budget.cost(key, entry.Data)
+	}
+	c.book.Lock()
+	defer c.book.Unlock()
+	if c.budget == nil {
+		c.policy.Add(key, entry)
+		c.expiry.set(key, entry.ExpiresAt)
+		return nil
+	}
+	if cost > c.budget.maxBytes {
+		c.policy.Remove(key)
+		c.expiry.remove(key)
+		c.budget.forget(key)
+		return ErrEntryTooLarge
+	}
+	c.policy.Add(key, entry)
+	c.expiry.set(key, entry.ExpiresAt)
+	c.budget.record(key, cost)
+	return nil
+}
+
+// enforceBudget - evict entries until the total cost is under the budget.  This must be called without holding any
+// key locks, and keys that are locked by someone else are skipped.
+func (c *inMemoryStore) enforceBudget() {
+	b := c.budget
+	if b == nil || b.Total() <= b.maxBytes || !b.evicting.TryLock() {
+		return
+	}
+	defer b.evicting.Unlock()
+	// the policy's keys are in eviction order
+	for _, k := range c.policy.Keys() {
+		if b.Total() <= b.lowWater {
+			return
+		}
+		key := k.(string)
+		l := c.keyLock(key)
+		if !l.TryLock() {
+			continue
+		}
+		c.remove(key, EvictReasonCapacity)
+		l.Unlock()
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EvictReason - why an entry left an InMemoryStore
+type EvictReason int
+
+const (
+	// EvictReasonCapacity - evicted by the eviction policy or the max bytes budget to make room
+	EvictReasonCapacity EvictReason = iota
+	// EvictReasonExpired - removed by a Get or the janitor because it expired
+	EvictReasonExpired
+	// EvictReasonDeleted - removed by Delete
+	EvictReasonDeleted
+	// EvictReasonReplaced - overwritten by Set, Replace or Update (the value is the old one)
+	EvictReasonReplaced
+	// EvictReasonFlushed - removed by Flush
+	EvictReasonFlushed
+)
+
+// String - the name of the reason (used as a metric label)
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonCapacity:
+		return "capacity"
+	case EvictReasonExpired:
+		return "expired"
+	case EvictReasonDeleted:
+		return "deleted"
+	case EvictReasonReplaced:
+		return "replaced"
+	case EvictReasonFlushed:
+		return "flushed"
+	}
+	return "unknown"
+}
+
+// EvictionHandler - called when an entry leaves an InMemoryStore, with the entry's data
+type EvictionHandler func(key string, value interface{}, reason EvictReason)
+
+type eviction struct {
+	key    string
+	value  interface{}
+	reason EvictReason
+}
+
+// evictions - the OnEvict handlers of a store, and the evictions waiting to be sent to them.  Evictions are queued
+// while the store is locked and dispatched once the operation has released its locks.
+type evictions struct {
+	tracking int32 // 1 once there's a handler, so stores without handlers don't queue anything
+
+	mu       sync.Mutex
+	handlers []EvictionHandler
+	pending  []eviction
+}
+
+func (e *evictions) enabled() bool {
+	return atomic.LoadInt32(&e.tracking) == 1
+}
+
+func (e *evictions) add(h EvictionHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers = append(e.handlers, h)
+	atomic.StoreInt32(&e.tracking, 1)
+}
+
+func (e *evictions) queue(key string, value interface{}, reason EvictReason) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, eviction{key: key, value: value, reason: reason})
+}
+
+// dispatch - send the pending evictions to the handlers (this must be called without holding any store locks)
+func (e *evictions) dispatch() {
+	if !e.enabled() {
+		return
+	}
+	e.mu.Lock()
+	pending, handlers := e.pending, e.handlers
+	e.pending = nil
+	e.mu.Unlock()
+	for _, ev := range pending {
+		for _, h := range handlers {
+			h(ev.key, ev.value, ev.reason)
+		}
+	}
+}
+
+// OnEvict - register a handler that's called whenever an entry leaves the store, with the reason.  Handlers are
+// called after the operation that removed the entry has released the store's locks (so they may use the store), but
+// they may be called from any goroutine, and the order of evictions from concurrent operations isn't guaranteed.
+func (c *InMemoryStore) OnEvict(h EvictionHandler) {
+	c.evictions.add(h)
+}
+
+// evicting - queue an eviction of key's entry, if anyone's listening (the caller must hold the key's lock)
+func (c *inMemoryStore) evicting(key string, reason EvictReason) {
+	if !c.evictions.enabled() {
+		return
+	}
+	if v, ok := c.policy.Peek(key); ok {
+		c.evictions.queue(key, v.(GenericCacheEntry).Data, reason)
+	}
+}
+
+// replacing - count and queue the eviction of the entry a Set is about to overwrite (the caller must hold the key's
+// lock)
+func (c *inMemoryStore) replacing(key string) {
+	if !c.evictions.enabled() && c.metrics == nil {
+		return
+	}
+	if v, ok := c.policy.Peek(key); ok {
+		entry := v.(GenericCacheEntry)
+		reason := EvictReasonReplaced
+		if entry.Expired() {
+			reason = EvictReasonExpired
+			c.metrics.expired(expiredOnRead)
+		}
+		c.metrics.evicted(reason, 1)
+		if c.evictions.enabled() {
+			c.evictions.queue(key, entry.Data, reason)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// defaultMaxExpiredPerTick - the default max number of expired entries the janitor removes per tick
+const defaultMaxExpiredPerTick = 10000
+
+// expiryIndex - the entries of a store that expire, in a min-heap by ExpiresAt, so the janitor only visits entries
+// that are due.  An InMemoryStore indexes them by key and an ArenaStore by their key's hash.  Updates for a key are
+// made while holding the key's lock.
+type expiryIndex[K comparable] struct {
+	mu    sync.Mutex
+	heap  expiryHeap[K]
+	items map[K]*expiryItem[K]
+}
+
+type expiryItem[K comparable] struct {
+	key       K
+	expiresAt int64
+	index     int
+}
+
+func newExpiryIndex[K comparable]() *expiryIndex[K] {
+	return &expiryIndex[K]{items: map[K]*expiryItem[K]{}}
+}
+
+// set - index the key by when it expires (0 means it never expires, so it's not indexed)
+func (x *expiryIndex[K]) set(key K, expiresAt int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	item, ok := x.items[key]
+	switch {
+	case expiresAt == 0 && ok:
+		heap.Remove(&x.heap, item.index)
+		delete(x.items, key)
+	case expiresAt == 0:
+	case ok:
+		item.expiresAt = expiresAt
+		heap.Fix(&x.heap, item.index)
+	default:
+		item = &expiryItem[K]{key: key, expiresAt: expiresAt}
+		heap.Push(&x.heap, item)
+		x.items[key] = item
+	}
+}
+
+func (x *expiryIndex[K]) remove(key K) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if item, ok := x.items[key]; ok {
+		heap.Remove(&x.heap, item.index)
+		delete(x.items, key)
+	}
+}
+
+func (x *expiryIndex[K]) reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.heap = nil
+	x.items = map[K]*expiryItem[K]{}
+}
+
+// Len - the number of indexed keys
+func (x *expiryIndex[K]) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.items)
+}
+
+// popDue - remove and return up to max keys (soonest first) that have expired by now (unix seconds): like
+// GenericCacheEntry.Expired, an entry is expired once its ExpiresAt second has started.  A key that's Set again after
+// it's popped is indexed again, so callers must recheck the entry under the key's lock.
+func (x *expiryIndex[K]) popDue(now int64, max int) []K {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var keys []K
+	for len(x.heap) > 0 && len(keys) < max && x.heap[0].expiresAt <= now {
+		item := heap.Pop(&x.heap).(*expiryItem[K])
+		delete(x.items, item.key)
+		keys = append(keys, item.key)
+	}
+	return keys
+}
+
+// expiryHeap - implements heap.Interface
+type expiryHeap[K comparable] []*expiryItem[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].expiresAt < h[j].expiresAt }
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K]) Push(x interface{}) {
+	item := x.(*expiryItem[K])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap[K]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
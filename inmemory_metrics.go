@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// expiredOnRead - the source of expirations found by Get, Add, etc
+	expiredOnRead = "read"
+	// expiredByJanitor - the source of expirations removed by the janitor (DeleteExpired)
+	expiredByJanitor = "janitor"
+)
+
+// storeMetrics - the counters of one store (resolved from the metric vecs once, so they're cheap to update).  A nil
+// *storeMetrics is a store without metrics.
+type storeMetrics struct {
+	hits        prometheus.Counter
+	misses      prometheus.Counter
+	sets        prometheus.Counter
+	evictions   map[EvictReason]prometheus.Counter
+	expirations map[string]prometheus.Counter
+	janitor     prometheus.Observer
+}
+
+// newStoreMetrics - the metrics for the store called name, registered with r.  The metrics of all the in memory
+// stores are told apart by their store label.
+func newStoreMetrics(r *metricsRegistry, name string) *storeMetrics {
+	store := []string{"store"}
+	m := &storeMetrics{
+		hits:        r.counter("inmemory_cache_hits_total", "Total number of Gets that found an entry in the in-memory cache", store, name),
+		misses:      r.counter("inmemory_cache_misses_total", "Total number of Gets that didn't find an entry in the in-memory cache", store, name),
+		sets:        r.counter("inmemory_cache_sets_total", "Total number of entries stored by Set, Add, Replace and Update in the in-memory cache", store, name),
+		evictions:   map[EvictReason]prometheus.Counter{},
+		expirations: map[string]prometheus.Counter{},
+		janitor:     r.summary("inmemory_cache_janitor_seconds", "How long the in-memory cache's janitor takes to remove expired entries", store, name),
+	}
+	for _, reason := range []EvictReason{EvictReasonCapacity, EvictReasonExpired, EvictReasonDeleted, EvictReasonReplaced, EvictReasonFlushed} {
+		m.evictions[reason] = r.counter("inmemory_cache_evictions_total", "Total number of entries that left the in-memory cache, by reason (capacity, expired, deleted, replaced or flushed)", []string{"store", "reason"}, name, reason.String())
+	}
+	for _, source := range []string{expiredOnRead, expiredByJanitor} {
+		m.expirations[source] = r.counter("inmemory_cache_expirations_total", "Total number of expired entries removed from the in-memory cache, by what found them (read or janitor)", []string{"store", "source"}, name, source)
+	}
+	return m
+}
+
+// storeMetricName - the store label for a store's metricLabel
+func storeMetricName(metricLabel string) string {
+	if len(metricLabel) != 0 {
+		return metricLabel
+	}
+	return "inmemory"
+}
+
+func (m *storeMetrics) hit() {
+	if m != nil {
+		m.hits.Inc()
+	}
+}
+
+func (m *storeMetrics) miss() {
+	if m != nil {
+		m.misses.Inc()
+	}
+}
+
+func (m *storeMetrics) set() {
+	if m != nil {
+		m.sets.Inc()
+	}
+}
+
+func (m *storeMetrics) evicted(reason EvictReason, n int) {
+	if m != nil && n > 0 {
+		m.evictions[reason].Add(float64(n))
+	}
+}
+
+func (m *storeMetrics) expired(source string) {
+	if m != nil {
+		m.expirations[source].Inc()
+	}
+}
+
+// janitorObserver - where the janitor's runtime is observed (nil for a store without metrics)
+func (m *storeMetrics) janitorObserver() prometheus.Observer {
+	if m == nil {
+		return nil
+	}
+	return m.janitor
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// snapshotVersion - the version of the snapshot format
+const snapshotVersion = 1
+
+// snapshotHeader - the first value in a snapshot
+type snapshotHeader struct {
+	Version int
+	Created int64
+}
+
+// snapshotEntry - an entry in a snapshot.  Entries are written in recency order (the next to be evicted first) so
+// a restore rebuilds the same order.
+type snapshotEntry struct {
+	Key       string
+	Data      interface{}
+	TimeAdded int64
+	ExpiresAt int64
+}
+
+// SnapshotSkippedError - Snapshot or Restore skipped some entries: Snapshot skips values gob can't encode (are their
+// types registered with gob.Register?) and Restore skips entries the store won't take (e.g. ErrEntryTooLarge).  The
+// rest of the snapshot was still written or restored.
+type SnapshotSkippedError struct {
+	// Op - Snapshot or Restore
+	Op string
+	// Keys - the keys that were skipped
+	Keys []string
+	// Errs - why each key was skipped
+	Errs []error
+}
+
+func (e *SnapshotSkippedError) Error() string {
+	if len(e.Keys) == 1 {
+		return fmt.Sprintf("InMemoryStore.%s: skipped key %s: %s", e.Op, e.Keys[0], e.Errs[0].Error())
+	}
+	return fmt.Sprintf("InMemoryStore.%s: skipped %d keys (%s): %s", e.Op, len(e.Keys), strings.Join(e.Keys, ", "), e.Errs[0].Error())
+}
+
+// add - record a skipped key
+func (e *SnapshotSkippedError) add(key string, err error) {
+	e.Keys = append(e.Keys, key)
+	e.Errs = append(e.Errs, err)
+}
+
+// orNil - e if any keys were skipped, otherwise nil (so a nil *SnapshotSkippedError isn't returned as a non-nil error)
+func (e *SnapshotSkippedError) orNil() error {
+	if len(e.Keys) == 0 {
+		return nil
+	}
+	return e
+}
+
+// errWriter - remembers the first write error, so a failed write can be told apart from a value gob can't encode
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// writeSnapshot - write the unexpired entries of the stores to w.  Entries whose data gob can't encode (e.g. types
+// that aren't registered with gob.Register) are skipped and returned in a *SnapshotSkippedError.
+func writeSnapshot(w io.Writer, stores ...*inMemoryStore) error {
+	ew := &errWriter{w: w}
+	enc := gob.NewEncoder(ew)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Created: time.Now().Unix()}); err != nil {
+		return fmt.Errorf("InMemoryStore.Snapshot: error writing header: %s", err.Error())
+	}
+	skipped := &SnapshotSkippedError{Op: "Snapshot"}
+	for _, c := range stores {
+		for _, k := range c.policy.Keys() {
+			v, ok := c.policy.Peek(k)
+			if !ok {
+				continue
+			}
+			entry := v.(GenericCacheEntry)
+			if entry.Expired() {
+				continue
+			}
+			if err := enc.Encode(snapshotEntry{Key: k.(string), Data: entry.Data, TimeAdded: entry.TimeAdded, ExpiresAt: entry.ExpiresAt}); err != nil {
+				if ew.err != nil {
+					return fmt.Errorf("InMemoryStore.Snapshot: error writing key %s: %s", k, ew.err.Error())
+				}
+				skipped.add(k.(string), err)
+			}
+		}
+	}
+	return skipped.orNil()
+}
+
+// readSnapshot - read a snapshot written by writeSnapshot, calling restore for each entry that hasn't expired.  The
+// entries restore fails for are returned in a *SnapshotSkippedError, unless the snapshot is corrupt.
+func readSnapshot(r io.Reader, restore func(key string, entry GenericCacheEntry) error) error {
+	dec := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("InMemoryStore.Restore: error reading header: %s", err.Error())
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("InMemoryStore.Restore: unsupported snapshot version %d", header.Version)
+	}
+	skipped := &SnapshotSkippedError{Op: "Restore"}
+	for {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return skipped.orNil()
+			}
+			return fmt.Errorf("InMemoryStore.Restore: error reading entry: %s", err.Error())
+		}
+		entry := GenericCacheEntry{Data: e.Data, TimeAdded: e.TimeAdded, ExpiresAt: e.ExpiresAt}
+		if entry.Expired() {
+			continue
+		}
+		if err := restore(e.Key, entry); err != nil {
+			skipped.add(e.Key, err)
+		}
+	}
+}
+
+// restore - add a restored entry, unless the key is already in the store (it's newer than the snapshot)
+func (c *inMemoryStore) restore(key string, entry GenericCacheEntry) error {
+	defer c.lockKey(key).Unlock()
+	if _, ok := c.live(key); ok {
+		return nil
+	}
+	return c.put(key, entry)
+}
+
+// Snapshot - write the store's unexpired entries to w, in recency order, so they can be loaded into another store
+// with Restore.  Entries are gob encoded, so the types of the values must be registered with gob.Register (entries
+// that can't be encoded are skipped, and returned in a *SnapshotSkippedError after the rest are written).
+func (c *InMemoryStore) Snapshot(w io.Writer) error {
+	return writeSnapshot(w, c.inMemoryStore)
+}
+
+// Restore - load the entries of a Snapshot into the store, keeping their expiry times and recency order.  Entries
+// that have expired since the snapshot and keys that are already in the store are skipped, and entries the store
+// won't take (e.g. ErrEntryTooLarge) are returned in a *SnapshotSkippedError.  If the snapshot is corrupt, the entries
+// before the corruption are kept and an error is returned.
+func (c *InMemoryStore) Restore(r io.Reader) error {
+	defer c.evictions.dispatch()
+	defer c.enforceBudget()
+	return readSnapshot(r, c.restore)
+}
+
+// SaveSnapshot - write a Snapshot to the store's WithSnapshotFile (call it on shutdown).  The file is still written
+// when some entries are skipped (see Snapshot).
+func (c *InMemoryStore) SaveSnapshot() error {
+	return saveSnapshotFile(c.snapshotFile, c.Snapshot)
+}
+
+// ErrNoSnapshotFile - SaveSnapshot was called for a store without WithSnapshotFile
+var ErrNoSnapshotFile = errors.New("cache: the store has no snapshot file")
+
+// saveSnapshotFile - write the snapshot to a temp file and rename it, so a crash can't leave a partial snapshot.  A
+// snapshot that skipped some entries is still saved, and its *SnapshotSkippedError is returned.
+func saveSnapshotFile(path string, snapshot func(io.Writer) error) error {
+	if path == "" {
+		return ErrNoSnapshotFile
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("InMemoryStore.SaveSnapshot: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	snapErr := snapshot(f)
+	if _, skipped := snapErr.(*SnapshotSkippedError); snapErr != nil && !skipped {
+		f.Close()
+		return snapErr
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("InMemoryStore.SaveSnapshot: %s", err.Error())
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("InMemoryStore.SaveSnapshot: %s", err.Error())
+	}
+	return snapErr
+}
+
+// restoreSnapshotFile - Restore from the snapshot file on start.  A missing file is a cold start, and a bad one
+// doesn't keep the store from starting: its error is logged to the WithSnapshotLogger logger (if there is one).
+func restoreSnapshotFile(path string, restore func(io.Reader) error, logger *logrus.Entry) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) && logger != nil {
+			logger.Errorf("InMemoryStore.Restore: error opening snapshot %s: %s", path, err.Error())
+		}
+		return
+	}
+	defer f.Close()
+	if err := restore(f); err != nil && logger != nil {
+		logger.Errorf("InMemoryStore.Restore: error restoring snapshot %s: %s", path, err.Error())
+	}
+}
@@ -1,10 +1,23 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Bose/cache/persistence"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -77,6 +90,648 @@ func TestInMemoryStore_Keys(t *testing.T) {
 	}
 }
 
+func TestInMemoryStore_AnyTypes(t *testing.T) {
+	anyTypes(t, newInMemoryStore)
+}
+
+func TestInMemoryStore_TypeMismatch(t *testing.T) {
+	c := newInMemoryStore(t, time.Hour)
+	if err := c.Set("string", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting string: %s", err)
+	}
+	var i int
+	err := c.Get("string", &i)
+	if mismatch, ok := err.(*TypeMismatchError); !ok || mismatch.Stored != "string" || mismatch.Target != "int" {
+		t.Errorf("expected a TypeMismatchError, got %v", err)
+	}
+	var s string
+	if err := c.Get("string", s); err == nil {
+		t.Errorf("expected an error for a non-pointer target")
+	}
+
+	// numbers are converted
+	if err := c.Set("int64", int64(42), persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting int64: %s", err)
+	}
+	if err := c.Get("int64", &i); err != nil || i != 42 {
+		t.Errorf("expected 42, got %d - %v", i, err)
+	}
+	var f float64
+	if err := c.Get("int64", &f); err != nil || f != 42 {
+		t.Errorf("expected 42, got %v - %v", f, err)
+	}
+	c.Set("float", 2.0, persistence.DEFAULT)
+	if err := c.Get("float", &i); err != nil || i != 2 {
+		t.Errorf("expected 2, got %d - %v", i, err)
+	}
+
+	// but only when it's lossless
+	lossy := []struct {
+		stored interface{}
+		target interface{}
+	}{
+		{1.9, new(int)},
+		{int64(300), new(int8)},
+		{-1, new(uint)},
+		{uint64(math.MaxUint64), new(int64)},
+		{1e300, new(float32)},
+		{1e20, new(int64)},
+	}
+	for _, l := range lossy {
+		c.Set("lossy", l.stored, persistence.DEFAULT)
+		if _, ok := c.Get("lossy", l.target).(*TypeMismatchError); !ok {
+			t.Errorf("expected a TypeMismatchError for %T %v into %T", l.stored, l.stored, l.target)
+		}
+	}
+}
+
+func TestInMemoryStore_MaxBytes(t *testing.T) {
+	cost := func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}
+	c, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithMaxBytes(1000), WithCostFunc(cost))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	value := string(make([]byte, 100))
+	for i := 0; i < 30; i++ {
+		if err := c.Set(fmt.Sprintf("key-%d", i), value, persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if total := c.budget.Total(); total > 1000 {
+			t.Fatalf("expected the total cost to stay under 1000, got %d", total)
+		}
+	}
+	if c.Len() > 10 {
+		t.Errorf("expected at most 10 entries, got %d", c.Len())
+	}
+	var s string
+	if err := c.Get("key-0", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the oldest entry to be evicted, got %v", err)
+	}
+	if err := c.Get("key-29", &s); err != nil {
+		t.Errorf("expected the newest entry to be kept, got %v", err)
+	}
+	if err := c.Set("too-large", string(make([]byte, 1001)), persistence.DEFAULT); err != ErrEntryTooLarge {
+		t.Errorf("expected ErrEntryTooLarge, got %v", err)
+	}
+	// a Set that's too large doesn't leave the old value behind
+	var reasons []EvictReason
+	c.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		if key == "key-28" {
+			reasons = append(reasons, reason)
+		}
+	})
+	if err := c.Set("key-28", string(make([]byte, 1001)), persistence.DEFAULT); err != ErrEntryTooLarge {
+		t.Errorf("expected ErrEntryTooLarge, got %v", err)
+	}
+	if err := c.Get("key-28", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the replaced entry to be gone, got %v", err)
+	}
+	if len(reasons) != 1 || reasons[0] != EvictReasonReplaced {
+		t.Errorf("expected the replaced entry to be evicted once, got %v", reasons)
+	}
+
+	if err := c.Delete("key-29"); err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+	if total := c.budget.Total(); total != int64(c.Len()*100) {
+		t.Errorf("expected the total to match the entries, got %d for %d entries", total, c.Len())
+	}
+	c.Flush()
+	if total := c.budget.Total(); total != 0 {
+		t.Errorf("expected 0 after a flush, got %d", total)
+	}
+}
+
+func TestInMemoryStore_MaxBytesEstimate(t *testing.T) {
+	// the costs of entries the policy evicts for maxEntries must be forgotten
+	c, err := NewInMemoryStore(10, time.Hour, 0, false, "", WithMaxBytes(1<<20))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := c.Set(fmt.Sprintf("key-%d", i), []byte("0123456789"), persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+	}
+	c.enforceBudget()
+	if len(c.budget.trackedKeys()) != c.Len() {
+		t.Errorf("expected the costs of evicted entries to be forgotten, got %d", len(c.budget.trackedKeys()))
+	}
+	if total := c.budget.Total(); total != int64(c.Len()*16) {
+		t.Errorf("expected the estimate to be key + value len, got %d for %d entries", total, c.Len())
+	}
+}
+
+func TestInMemoryStore_MaxBytesConcurrent(t *testing.T) {
+	// Sets at capacity evict other goroutines' keys while they're being added (run with -race)
+	cost := func(key string, value interface{}) int64 {
+		return int64(len(key) + len(value.(string)))
+	}
+	c, err := NewInMemoryStore(16, time.Hour, 0, false, "", WithMaxBytes(1<<20), WithCostFunc(cost))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				c.Set(fmt.Sprintf("key-%d", (g*31+i)%64), fmt.Sprintf("%0*d", i%20, i), persistence.DEFAULT)
+			}
+		}(g)
+	}
+	wg.Wait()
+	var live int64
+	for _, k := range c.policy.Keys() {
+		v, _ := c.policy.Peek(k)
+		live += cost(k.(string), v.(GenericCacheEntry).Data)
+	}
+	if total := c.budget.Total(); total != live {
+		t.Errorf("expected the total cost to be the cost of the live entries (%d), got %d", live, total)
+	}
+	if n := len(c.budget.trackedKeys()); n != c.Len() {
+		t.Errorf("expected the costs of %d entries, got %d", c.Len(), n)
+	}
+	if n := c.expiry.Len(); n != c.Len() {
+		t.Errorf("expected %d entries in the expiry index, got %d", c.Len(), n)
+	}
+}
+
+func TestInMemoryStore_ExpiryIndex(t *testing.T) {
+	c, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithEvictionPolicy(NewLRUPolicy), WithMaxExpiredPerTick(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	past := time.Now().Unix() - 10
+	for i := 0; i < 5; i++ {
+		c.put(fmt.Sprintf("expired-%d", i), GenericCacheEntry{Data: i, ExpiresAt: past + int64(i)})
+	}
+	c.Set("live", 1, time.Hour)
+	c.Set("forever", 1, persistence.FOREVER)
+	if n := c.expiry.Len(); n != 6 {
+		t.Errorf("expected 6 indexed entries (forever isn't), got %d", n)
+	}
+
+	// each tick removes at most 2, the ones that expired first, without promoting the live entries
+	before := c.Keys()
+	c.DeleteExpired()
+	if c.Len() != 5 || c.policy.Contains("expired-0") || c.policy.Contains("expired-1") || !c.policy.Contains("expired-2") {
+		t.Errorf("expected expired-0 and expired-1 to be removed, got %v", c.Keys())
+	}
+	after := c.Keys()
+	for i, k := range after {
+		if k != before[i+2] {
+			t.Errorf("expected DeleteExpired to keep the recency order %v, got %v", before[2:], after)
+			break
+		}
+	}
+	c.DeleteExpired()
+	c.DeleteExpired()
+	if c.Len() != 2 || c.expiry.Len() != 1 {
+		t.Errorf("expected live and forever to be left, got %v (%d indexed)", c.Keys(), c.expiry.Len())
+	}
+
+	// re-setting an entry re-indexes it, and deletes and flushes unindex it
+	c.put("live", GenericCacheEntry{Data: 2, ExpiresAt: past})
+	c.DeleteExpired()
+	if c.policy.Contains("live") || c.expiry.Len() != 0 {
+		t.Errorf("expected the re-set entry to expire, got %v (%d indexed)", c.Keys(), c.expiry.Len())
+	}
+	c.Set("deleted", 1, time.Hour)
+	c.Delete("deleted")
+	c.Set("flushed", 1, time.Hour)
+	c.Flush()
+	if n := c.expiry.Len(); n != 0 {
+		t.Errorf("expected no indexed entries, got %d", n)
+	}
+
+	// an entry is due as soon as its ExpiresAt second starts, like GenericCacheEntry.Expired
+	x := newExpiryIndex[string]()
+	x.set("due", 100)
+	x.set("later", 101)
+	if due := x.popDue(100, 10); len(due) != 1 || due[0] != "due" {
+		t.Errorf("expected due to be popped at its ExpiresAt, got %v", due)
+	}
+
+	// entries the policy evicts are unindexed too
+	small, _ := NewInMemoryStore(2, time.Hour, 0, false, "")
+	for i := 0; i < 10; i++ {
+		small.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	if n := small.expiry.Len(); n != small.Len() {
+		t.Errorf("expected %d indexed entries, got %d", small.Len(), n)
+	}
+}
+
+func TestInMemoryStore_OnEvict(t *testing.T) {
+	c, err := NewInMemoryStore(3, time.Hour, 0, false, "", WithEvictionPolicy(NewLRUPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	c.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		// handlers run without the store's locks, so they can use the store
+		c.Len()
+		got = append(got, fmt.Sprintf("%s=%v:%s", key, value, reason))
+	})
+	c.Set("a", 1, time.Hour)
+	c.Set("a", 2, time.Hour)
+	c.Replace("a", 3, time.Hour)
+	c.Set("b", 1, time.Hour)
+	c.Set("c", 1, time.Hour)
+	c.Set("d", 1, time.Hour)
+	c.Delete("b")
+	c.put("e", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	var v int
+	c.Get("e", &v)
+	c.Increment("c", 1)
+	c.Flush()
+	expected := []string{"a=1:replaced", "a=2:replaced", "a=3:capacity", "b=1:deleted", "e=1:expired", "d=1:flushed", "c=2:flushed"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	got = nil
+	c.put("janitor", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	c.DeleteExpired()
+	if len(got) != 1 || got[0] != "janitor=1:expired" {
+		t.Errorf("expected the janitor's expiration, got %v", got)
+	}
+
+	budget, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithMaxBytes(10), WithCostFunc(func(string, interface{}) int64 { return 5 }))
+	var reasons []EvictReason
+	budget.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		reasons = append(reasons, reason)
+	})
+	for i := 0; i < 3; i++ {
+		budget.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	if len(reasons) != 2 || reasons[0] != EvictReasonCapacity || reasons[1] != EvictReasonCapacity {
+		t.Errorf("expected 2 capacity evictions to get under the budget, got %v", reasons)
+	}
+}
+
+func TestGenericCache_OnEvict(t *testing.T) {
+	gc := newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache)
+	evicted := map[string]EvictReason{}
+	if err := gc.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		evicted[key] = reason
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	gc.Set("key", "value", time.Hour)
+	gc.Delete("key")
+	if reason, ok := evicted["key"]; !ok || reason != EvictReasonDeleted {
+		t.Errorf("expected key to be deleted, got %v", evicted)
+	}
+
+	sharded, err := NewShardedInMemoryStore(4, maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	shardedCache := NewCacheWithPool(sharded, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false)
+	var flushed int
+	shardedCache.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		flushed++
+	})
+	for i := 0; i < 10; i++ {
+		shardedCache.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	shardedCache.Flush()
+	if flushed != 10 {
+		t.Errorf("expected 10 flushed entries from all the shards, got %d", flushed)
+	}
+
+	redisCache := NewCacheWithPool(persistence.NewRedisCacheWithPool(nil, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), false)
+	if err := redisCache.OnEvict(func(string, interface{}, EvictReason) {}); err != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport for redis, got %v", err)
+	}
+}
+
+// unregisteredValue - a type that isn't registered with gob, so it can't be snapshotted
+type unregisteredValue struct{ X int }
+
+func TestInMemoryStore_Snapshot(t *testing.T) {
+	gob.Register(testStruct{})
+	c, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithEvictionPolicy(NewLRUPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set("a", "A", time.Hour)
+	c.Set("b", 2, time.Minute)
+	c.Set("forever", []byte("bytes"), persistence.FOREVER)
+	c.Set("struct", testStruct{Name: "foo", Count: 1}, time.Hour)
+	c.Set("unregistered", unregisteredValue{X: 1}, time.Hour)
+	c.put("expired", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	var s string
+	c.Get("a", &s) // a is the most recently used
+
+	var buf bytes.Buffer
+	err = c.Snapshot(&buf)
+	if skipped, ok := err.(*SnapshotSkippedError); !ok || !reflect.DeepEqual(skipped.Keys, []string{"unregistered"}) {
+		t.Fatalf("expected the unregistered entry to be skipped, got %v", err)
+	}
+	restored, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithEvictionPolicy(NewLRUPolicy))
+	restored.Set("b", 3, time.Hour)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []interface{}{"b", "forever", "struct", "a"}
+	if keys := restored.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected the recency order %v without the expired and unencodable entries, got %v", expected, keys)
+	}
+	var b int
+	if restored.Get("b", &b); b != 3 {
+		t.Errorf("expected Restore to keep the existing value for b, got %d", b)
+	}
+	var original, entry GenericCacheEntry
+	c.Get("struct", &original)
+	restored.Get("struct", &entry)
+	if entry.ExpiresAt != original.ExpiresAt || entry.TimeAdded != original.TimeAdded || entry.Data.(testStruct).Name != "foo" {
+		t.Errorf("expected %v, got %v", original, entry)
+	}
+	restored.Get("forever", &entry)
+	if entry.ExpiresAt != 0 || string(entry.Data.([]byte)) != "bytes" {
+		t.Errorf("expected forever to never expire, got %v", entry)
+	}
+
+	if err := restored.Restore(bytes.NewBufferString("not a snapshot")); err == nil {
+		t.Errorf("expected an error for a corrupt snapshot")
+	}
+	if err := restored.SaveSnapshot(); err != ErrNoSnapshotFile {
+		t.Errorf("expected ErrNoSnapshotFile, got %v", err)
+	}
+
+	// entries the store won't take are returned, not logged
+	buf.Reset()
+	c.Delete("unregistered")
+	c.Set("big", make([]byte, 1024), time.Hour)
+	if err := c.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	small, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithMaxBytes(512))
+	err = small.Restore(&buf)
+	if skipped, ok := err.(*SnapshotSkippedError); !ok || !reflect.DeepEqual(skipped.Keys, []string{"big"}) || skipped.Errs[0] != ErrEntryTooLarge {
+		t.Errorf("expected big to be skipped, got %v", err)
+	}
+	if small.Len() != 4 {
+		t.Errorf("expected the other entries to be restored, got %d", small.Len())
+	}
+}
+
+func TestInMemoryStore_SnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c, err := NewShardedInMemoryStore(4, maxEntries, time.Hour, 0, false, "", WithSnapshotFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	if err := c.SaveSnapshot(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a sharded snapshot can be restored into an InMemoryStore (and vice versa)
+	warm, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithSnapshotFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if warm.Len() != 10 {
+		t.Errorf("expected 10 entries to be restored on start, got %d", warm.Len())
+	}
+	warm.Delete("key-0")
+	if err := warm.SaveSnapshot(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	warmSharded, _ := NewShardedInMemoryStore(2, maxEntries, time.Hour, 0, false, "", WithSnapshotFile(path))
+	if warmSharded.Len() != 9 {
+		t.Errorf("expected 9 entries to be restored on start, got %d", warmSharded.Len())
+	}
+
+	cold, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithSnapshotFile(filepath.Join(t.TempDir(), "missing")))
+	if err != nil || cold.Len() != 0 {
+		t.Errorf("expected a missing snapshot to be a cold start, got %d entries - %v", cold.Len(), err)
+	}
+
+	// a snapshot with skipped entries is still saved
+	warm.Set("unregistered", unregisteredValue{X: 1}, time.Hour)
+	if err := warm.SaveSnapshot(); err == nil {
+		t.Errorf("expected the unregistered entry to be reported")
+	}
+	rewarmed, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithSnapshotFile(path))
+	if rewarmed.Len() != 9 {
+		t.Errorf("expected 9 entries to be restored on start, got %d", rewarmed.Len())
+	}
+
+	// a bad snapshot is logged to the WithSnapshotLogger logger, not the global one
+	corrupt := filepath.Join(t.TempDir(), "corrupt")
+	os.WriteFile(corrupt, []byte("not a snapshot"), 0600)
+	var logged bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logged
+	if _, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithSnapshotFile(corrupt), WithSnapshotLogger(logrus.NewEntry(logger))); err != nil {
+		t.Fatalf("expected a bad snapshot not to keep the store from starting, got %s", err)
+	}
+	if !strings.Contains(logged.String(), "error restoring snapshot") {
+		t.Errorf("expected the bad snapshot to be logged, got %q", logged.String())
+	}
+}
+
+// counterValue - the current value of a prometheus counter
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestInMemoryStore_Metrics(t *testing.T) {
+	c, err := NewInMemoryStore(2, time.Hour, 0, true, "metrics_test_store", WithEvictionPolicy(NewLRUPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	other, _ := NewInMemoryStore(2, time.Hour, 0, true, "metrics_test_other")
+	other.Set("other", 1, time.Hour)
+
+	var v int
+	c.Set("a", 1, time.Hour)
+	c.Set("a", 2, time.Hour)
+	c.Get("a", &v)
+	c.Get("missing", &v)
+	c.Set("b", 1, time.Hour)
+	c.Set("c", 1, time.Hour)
+	c.put("expired", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	c.Get("expired", &v)
+	c.put("janitor", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	c.DeleteExpired()
+	c.Set("d", 1, time.Hour)
+	c.Delete("d")
+	c.Flush()
+
+	m := c.metrics
+	expected := map[string]float64{
+		"hits":               1,
+		"misses":             2,
+		"sets":               5,
+		"evicted capacity":   2,
+		"evicted replaced":   1,
+		"evicted expired":    2,
+		"evicted deleted":    1,
+		"evicted flushed":    1,
+		"expired on read":    1,
+		"expired by janitor": 1,
+	}
+	got := map[string]float64{
+		"hits":               counterValue(t, m.hits),
+		"misses":             counterValue(t, m.misses),
+		"sets":               counterValue(t, m.sets),
+		"evicted capacity":   counterValue(t, m.evictions[EvictReasonCapacity]),
+		"evicted replaced":   counterValue(t, m.evictions[EvictReasonReplaced]),
+		"evicted expired":    counterValue(t, m.evictions[EvictReasonExpired]),
+		"evicted deleted":    counterValue(t, m.evictions[EvictReasonDeleted]),
+		"evicted flushed":    counterValue(t, m.evictions[EvictReasonFlushed]),
+		"expired on read":    counterValue(t, m.expirations[expiredOnRead]),
+		"expired by janitor": counterValue(t, m.expirations[expiredByJanitor]),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if sets := counterValue(t, other.metrics.sets); sets != 1 {
+		t.Errorf("expected the stores to have their own counters, got %v sets for the other store", sets)
+	}
+
+	sharded, _ := NewShardedInMemoryStore(4, maxEntries, time.Hour, 0, true, "metrics_test_sharded")
+	for i := 0; i < 10; i++ {
+		sharded.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	if sets := counterValue(t, sharded.shards[0].metrics.sets); sets != 10 {
+		t.Errorf("expected the shards to share the counters, got %v sets", sets)
+	}
+}
+
+func TestInMemoryStore_CopyOnRead(t *testing.T) {
+	shared, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	isolated, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithCopyOnRead(true))
+	for _, c := range []*InMemoryStore{shared, isolated} {
+		value := []string{"a", "b"}
+		c.Set("slice", value, time.Hour)
+		value[0] = "changed by the setter"
+		var got []string
+		c.Get("slice", &got)
+		got[1] = "changed by a reader"
+		var again []string
+		c.Get("slice", &again)
+		var entry GenericCacheEntry
+		c.Get("slice", &entry)
+		entry.Data.([]string)[1] = "changed by an entry reader"
+		c.Get("slice", &again)
+		if c.copyOnRead && (again[0] != "a" || again[1] != "b") {
+			t.Errorf("expected an isolated value, got %v", again)
+		}
+		if !c.copyOnRead && again[0] == "a" {
+			t.Errorf("expected values to be shared by default, got %v", again)
+		}
+	}
+}
+
+func TestInMemoryStore_IncrDecrIntegerTypes(t *testing.T) {
+	c := newInMemoryStore(t, time.Hour).(*InMemoryStore)
+	values := map[string]interface{}{
+		"int": int(10), "int8": int8(10), "int16": int16(10), "int32": int32(10), "int64": int64(10),
+		"uint": uint(10), "uint8": uint8(10), "uint16": uint16(10), "uint32": uint32(10), "uint64": uint64(10),
+	}
+	for key, value := range values {
+		if err := c.Set(key, value, persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+		if n, err := c.Increment(key, 5); err != nil || n != 15 {
+			t.Errorf("%s: expected 15, got %d - %v", key, n, err)
+		}
+		if n, err := c.Decrement(key, 20); err != nil || n != 0 {
+			t.Errorf("%s: expected capped at 0, got %d - %v", key, n, err)
+		}
+		var stored interface{}
+		if err := c.Get(key, &stored); err != nil {
+			t.Fatalf("Error getting %s: %s", key, err)
+		}
+		if stored != reflect.Zero(reflect.TypeOf(value)).Interface() {
+			t.Errorf("%s: expected the counter to keep its type, got %T", key, stored)
+		}
+	}
+	// wraparound of a small type
+	if err := c.Set("uint8", uint8(250), persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting uint8: %s", err)
+	}
+	if n, err := c.Increment("uint8", 10); err != nil || n != 4 {
+		t.Errorf("expected wraparound 4, got %d - %v", n, err)
+	}
+	if err := c.Set("string", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting string: %s", err)
+	}
+	if _, err := c.Increment("string", 1); err != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
+
+func TestInMemoryStore_ConcurrentIncrement(t *testing.T) {
+	c := newInMemoryStore(t, time.Hour).(*InMemoryStore)
+	if err := c.Set("counter", int64(0), persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting counter: %s", err)
+	}
+	const workers, incrs = 20, 100
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incrs; j++ {
+				if _, err := c.Increment("counter", 1); err != nil {
+					t.Errorf("Error incrementing: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var stored interface{}
+	if err := c.Get("counter", &stored); err != nil || stored != int64(workers*incrs) {
+		t.Errorf("expected %d, got %v - %v", workers*incrs, stored, err)
+	}
+}
+
+func TestInMemoryStore_ConcurrentAdd(t *testing.T) {
+	c := newInMemoryStore(t, time.Hour).(*InMemoryStore)
+	for round := 0; round < 50; round++ {
+		var added int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := c.Add("key", i, persistence.DEFAULT); err == nil {
+					atomic.AddInt32(&added, 1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if added != 1 {
+			t.Fatalf("expected exactly one Add to succeed, got %d", added)
+		}
+		if err := c.Delete("key"); err != nil {
+			t.Fatalf("Error deleting: %s", err)
+		}
+	}
+}
+
+// inMemEntryFactory - the in memory stores can create entries
+type inMemEntryFactory interface {
+	NewGenericCacheEntry(data interface{}, exp time.Duration) (GenericCacheEntry, error)
+}
+
 func inMemTypicalGetSet(t *testing.T, newCache cacheFactory) {
 	var err error
 	c := newCache(t, time.Hour)
@@ -89,7 +744,7 @@ func inMemTypicalGetSet(t *testing.T, newCache cacheFactory) {
 	var tValue string
 	err = c.(persistence.CacheStore).Get("value", &tValue)
 	value = ""
-	err = c.(*InMemoryStore).Get("value", &value)
+	err = c.(persistence.CacheStore).Get("value", &value)
 	if err != nil {
 		t.Errorf("Error getting a value: %s", err)
 	}
@@ -99,7 +754,7 @@ func inMemTypicalGetSet(t *testing.T, newCache cacheFactory) {
 
 	value = "encrypted-data"
 	exp := 3 * time.Minute
-	entry, err := c.(*InMemoryStore).NewGenericCacheEntry(value, exp)
+	entry, err := c.(inMemEntryFactory).NewGenericCacheEntry(value, exp)
 	if err != nil {
 		t.Errorf("Error creating entry: %s", err)
 	}
@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// invalidationQueueLen - how many invalidations can wait to be published before they're dropped (which makes the
+	// subscribers flush, since they see a gap in the sequence)
+	invalidationQueueLen = 1024
+	// invalidationPingInterval - how often the subscription is pinged, so a dead connection is noticed
+	invalidationPingInterval = 10 * time.Second
+	// invalidationMaxBackoff - the max wait between attempts to resubscribe
+	invalidationMaxBackoff = 30 * time.Second
+
+	invalidateKey   = "del"
+	invalidateFlush = "flush"
+)
+
+// InvalidationBus - keeps the local (L1) stores of several instances consistent: the writes and deletes of the
+// GenericCaches created WithInvalidationBus are published on a Redis channel, and every other instance subscribed
+// to the channel deletes those keys from its local stores.
+//
+// Messages are "instanceID seq op key", where seq counts the messages of each instance.  A subscriber that sees a
+// gap in an instance's sequence (a dropped or failed publish) flushes its local stores, and so does a subscriber
+// that had to reconnect (e.g. after a failover), since it may have missed messages while it was disconnected.
+type InvalidationBus struct {
+	pool       *redis.Pool
+	channel    string
+	instanceID string
+	queue      chan invalidation
+	dropped    int32
+	stop       chan struct{}
+	published  chan struct{}
+	logger     *logrus.Entry
+
+	mu         sync.Mutex
+	targets    []persistence.CacheStore
+	psc        *redis.PubSubConn // the current subscription (nil while there isn't one)
+	subscribed chan struct{}     // closed when the subscriber stops (nil until Subscribe is called)
+	closed     bool
+
+	// lastSeq - the last seq received from each instance (only used by the subscriber)
+	lastSeq map[string]uint64
+}
+
+type invalidation struct {
+	op  string
+	key string
+}
+
+// NewInvalidationBus - create a bus that publishes and subscribes on the channel using the pool (the subscription
+// uses its own connection from the pool's Dial, since a pooled connection can't be returned to the pool mid-subscription).  Its errors are logged WithLogger (defaults to
+// logrus's standard logger).
+func NewInvalidationBus(pool *redis.Pool, channel string, opt ...Option) *InvalidationBus {
+	opts := GetOpts(opt...)
+	logger := opts[optionWithLogger].(*logrus.Entry)
+	if logger == nil {
+		logger = logrus.NewEntry(logrus.StandardLogger())
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	b := &InvalidationBus{
+		pool:       pool,
+		channel:    channel,
+		instanceID: hex.EncodeToString(id),
+		queue:      make(chan invalidation, invalidationQueueLen),
+		stop:       make(chan struct{}),
+		published:  make(chan struct{}),
+		logger:     logger,
+	}
+	go b.publishLoop()
+	return b
+}
+
+// InstanceID - the id of this instance in the bus's messages
+func (b *InvalidationBus) InstanceID() string {
+	return b.instanceID
+}
+
+// Invalidate - publish that key changed, so the other instances delete it from their local stores.  It doesn't
+// block: invalidations are published in order by a background goroutine.
+func (b *InvalidationBus) Invalidate(key string) {
+	b.enqueue(invalidation{op: invalidateKey, key: key})
+}
+
+// InvalidateAll - publish that everything changed, so the other instances flush their local stores
+func (b *InvalidationBus) InvalidateAll() {
+	b.enqueue(invalidation{op: invalidateFlush})
+}
+
+func (b *InvalidationBus) enqueue(inv invalidation) {
+	if b == nil {
+		return
+	}
+	select {
+	case <-b.stop:
+		return
+	case b.queue <- inv:
+	default:
+		atomic.StoreInt32(&b.dropped, 1)
+		b.logger.Warnf("InvalidationBus.Invalidate: channel %s - the queue is full, dropping the invalidation of %q", b.channel, inv.key)
+	}
+}
+
+// publishLoop - publish the queued invalidations, in order, until the bus is closed and the queue is drained
+func (b *InvalidationBus) publishLoop() {
+	defer close(b.published)
+	var seq uint64
+	for {
+		var inv invalidation
+		select {
+		case inv = <-b.queue:
+		case <-b.stop:
+			select {
+			case inv = <-b.queue:
+			default:
+				return
+			}
+		}
+		if atomic.CompareAndSwapInt32(&b.dropped, 1, 0) {
+			// skip a seq so the subscribers see the gap
+			seq++
+		}
+		seq++
+		msg := fmt.Sprintf("%s %d %s %s", b.instanceID, seq, inv.op, inv.key)
+		conn := b.pool.Get()
+		if _, err := conn.Do("PUBLISH", b.channel, msg); err != nil {
+			b.logger.Errorf("InvalidationBus.publish: channel %s error == %s", b.channel, err.Error())
+		}
+		conn.Close()
+	}
+}
+
+// Subscribe - delete the keys invalidated by other instances from the stores (e.g. their L1 InMemoryStore).  The
+// first call starts the subscription, which is reconnected until the bus is closed.  A GenericCache is unwrapped to
+// its store, so its own invalidations aren't published again.
+func (b *InvalidationBus) Subscribe(stores ...persistence.CacheStore) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range stores {
+		if c, ok := s.(*GenericCache); ok {
+			s = c.Cache.(persistence.CacheStore)
+		}
+		b.targets = append(b.targets, s)
+	}
+	if b.subscribed == nil && !b.closed {
+		b.subscribed = make(chan struct{})
+		go b.subscribeLoop(b.subscribed)
+	}
+}
+
+// subscribeLoop - subscribe, and resubscribe with a backoff whenever the subscription fails, until the bus is closed
+func (b *InvalidationBus) subscribeLoop(done chan struct{}) {
+	defer close(done)
+	backoff := poolDrainInterval
+	for reconnect := false; ; reconnect = true {
+		start := time.Now()
+		err := b.subscribe(reconnect)
+		if b.isClosed() {
+			return
+		}
+		if time.Since(start) > invalidationMaxBackoff {
+			// it was subscribed for a while, so this isn't a retry of a failing reconnect
+			backoff = poolDrainInterval
+		}
+		b.logger.Warnf("InvalidationBus.Subscribe: channel %s error == %v (resubscribing in %s)", b.channel, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-b.stop:
+			return
+		}
+		if backoff *= 2; backoff > invalidationMaxBackoff {
+			backoff = invalidationMaxBackoff
+		}
+	}
+}
+
+// subscribe - receive the channel's messages until the connection fails or the bus is closed.  When reconnecting,
+// the local stores are flushed once the subscription is back.
+func (b *InvalidationBus) subscribe(reconnect bool) error {
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	psc := &redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(b.channel); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.psc = psc
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.psc = nil
+		b.mu.Unlock()
+	}()
+
+	// the pinger is stopped before the connection is closed
+	stopPing, pingDone := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stopPing)
+		<-pingDone
+	}()
+	go func() {
+		defer close(pingDone)
+		ping := time.NewTicker(invalidationPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ping.C:
+				b.mu.Lock()
+				psc.Ping("")
+				b.mu.Unlock()
+			case <-stopPing:
+				return
+			}
+		}
+	}()
+
+	b.lastSeq = map[string]uint64{}
+	for {
+		// a ping is answered well within this, so a timeout means the connection is dead
+		switch v := psc.ReceiveWithTimeout(2 * invalidationPingInterval).(type) {
+		case redis.Message:
+			b.receive(string(v.Data))
+		case redis.Subscription:
+			if v.Kind == "subscribe" && reconnect {
+				b.flushTargets("resubscribed")
+			}
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// dial - a connection for the subscription from the pool's Dial (which ExportPoolMetrics instruments).  It isn't
+// borrowed from the pool, since a connection can't be handed back to the pool mid-subscription.
+func (b *InvalidationBus) dial() (redis.Conn, error) {
+	if b.pool.Dial == nil {
+		return nil, fmt.Errorf("InvalidationBus.subscribe: channel %s - the pool has no Dial", b.channel)
+	}
+	return b.pool.Dial()
+}
+
+// receive - apply a message from another instance to the local stores
+func (b *InvalidationBus) receive(msg string) {
+	parts := strings.SplitN(msg, " ", 4)
+	if len(parts) != 4 {
+		b.logger.Warnf("InvalidationBus.receive: channel %s - ignoring a malformed message %q", b.channel, msg)
+		return
+	}
+	instanceID, op, key := parts[0], parts[2], parts[3]
+	if instanceID == b.instanceID {
+		return
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		b.logger.Warnf("InvalidationBus.receive: channel %s - ignoring a malformed message %q", b.channel, msg)
+		return
+	}
+	last, seen := b.lastSeq[instanceID]
+	b.lastSeq[instanceID] = seq
+	if seen && seq != last+1 {
+		b.flushTargets(fmt.Sprintf("missed messages from %s", instanceID))
+		return
+	}
+	switch op {
+	case invalidateKey:
+		for _, s := range b.stores() {
+			s.Delete(key)
+		}
+	case invalidateFlush:
+		b.flushTargets(fmt.Sprintf("flushed by %s", instanceID))
+	}
+}
+
+// stores - a copy of the stores to invalidate, so they can be used without holding the lock
+func (b *InvalidationBus) stores() []persistence.CacheStore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]persistence.CacheStore(nil), b.targets...)
+}
+
+// flushTargets - flush the local stores, since they may be stale
+func (b *InvalidationBus) flushTargets(why string) {
+	b.logger.Infof("InvalidationBus: channel %s - flushing the local stores (%s)", b.channel, why)
+	for _, s := range b.stores() {
+		if err := s.Flush(); err != nil {
+			b.logger.Errorf("InvalidationBus.flush: channel %s error == %s", b.channel, err.Error())
+		}
+	}
+}
+
+func (b *InvalidationBus) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// Close - Shutdown, waiting at most defaultCloseTimeout
+func (b *InvalidationBus) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return b.Shutdown(ctx)
+}
+
+// Shutdown - publish the queued invalidations and stop the subscription (the pool isn't closed).  It's idempotent,
+// and it returns ctx's error if ctx is done first.
+func (b *InvalidationBus) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+		if b.psc != nil {
+			b.psc.Unsubscribe()
+		}
+	}
+	subscribed := b.subscribed
+	b.mu.Unlock()
+	for _, done := range []chan struct{}{b.published, subscribed} {
+		if done == nil {
+			continue
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
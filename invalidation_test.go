@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// waitFor - wait (up to 5s) for cond to be true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidationBus(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	addr := m.Addr()
+	pool := &redis.Pool{
+		MaxIdle: 4,
+		Dial:    func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+		// the idle conns don't survive the restart below
+		TestOnBorrow: func(c redis.Conn, _ time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	const channel = "invalidations"
+
+	writer := NewInvalidationBus(pool, channel)
+	defer writer.Close()
+	l2 := NewCacheWithPool(NewRedisStore(pool, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), false, WithInvalidationBus(writer))
+
+	reader := NewInvalidationBus(pool, channel)
+	l1, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	reader.Subscribe(NewCacheWithPool(l1, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false))
+	waitFor(t, "the subscription", func() bool { return m.PubSubNumSub(channel)[channel] == 1 })
+
+	for _, k := range []string{"set", "deleted", "counter", "kept"} {
+		l1.Set(k, 1, time.Hour)
+	}
+	l2.Set("set", "new", time.Minute)
+	l2.Set("counter", 1, time.Minute)
+	l2.Increment("counter", 1)
+	l2.Delete("deleted")
+	// the reader's own invalidations aren't applied to its stores
+	reader.Invalidate("kept")
+	waitFor(t, "the invalidations", func() bool { return l1.Len() == 1 })
+	var v int
+	if err := l1.Get("kept", &v); err != nil {
+		t.Errorf("expected the reader's own invalidation to be ignored, got %s", err)
+	}
+
+	// a gap in an instance's sequence flushes the local stores
+	l1.Set("other", 1, time.Hour)
+	m.Publish(channel, "x 1 del a")
+	m.Publish(channel, "x 3 del b")
+	waitFor(t, "the flush after a gap", func() bool { return l1.Len() == 0 })
+
+	// and so does resubscribing, since messages may have been missed
+	l1.Set("before-failover", 1, time.Hour)
+	m.Close()
+	m.Restart()
+	waitFor(t, "the flush after resubscribing", func() bool { return l1.Len() == 0 })
+	waitFor(t, "the resubscription", func() bool { return m.PubSubNumSub(channel)[channel] == 1 })
+	l1.Set("after-failover", 1, time.Hour)
+	l2.Delete("after-failover")
+	waitFor(t, "the invalidation after resubscribing", func() bool { return l1.Len() == 0 })
+
+	if err := reader.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	waitFor(t, "the unsubscribe", func() bool { return m.PubSubNumSub(channel)[channel] == 0 })
+	if err := reader.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %s", err)
+	}
+	reader.Invalidate("closed")
+}
+
+// messageHook - a logrus hook that sends the logged messages to a channel
+type messageHook chan string
+
+func (h messageHook) Levels() []logrus.Level { return logrus.AllLevels }
+func (h messageHook) Fire(e *logrus.Entry) error {
+	h <- e.Message
+	return nil
+}
+
+func TestInvalidationBus_WithLogger(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	const channel = "invalidations"
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	messages := make(messageHook, 10)
+	logger.AddHook(messages)
+	bus := NewInvalidationBus(pool, channel, WithLogger(logrus.NewEntry(logger)))
+	defer bus.Close()
+	l1, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	bus.Subscribe(l1)
+	waitFor(t, "the subscription", func() bool { return m.PubSubNumSub(channel)[channel] == 1 })
+
+	m.Publish(channel, "malformed")
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "malformed") {
+			t.Errorf("expected the malformed message to be logged, got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the bus to log to its logger")
+	}
+
+	// a pool without a Dial can't subscribe, which is logged instead of panicking
+	noDial := NewInvalidationBus(&redis.Pool{}, channel, WithLogger(logrus.NewEntry(logger)))
+	defer noDial.Close()
+	noDial.Subscribe(l1)
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "no Dial") {
+			t.Errorf("expected the missing Dial to be logged, got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the bus to log the missing Dial")
+	}
+}
+
+func TestInvalidationBus_Nil(t *testing.T) {
+	// caches without a bus don't publish
+	var bus *InvalidationBus
+	bus.Invalidate("key")
+	bus.InvalidateAll()
+	c := NewCacheWithPool(persistence.NewInMemoryStore(time.Hour), Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false)
+	if err := c.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"math/rand"
+	"time"
+)
+
+// expiryJitter - shortens expiries by a random amount, so entries that are set together (e.g. bulk loaded with the
+// same DefaultExp) don't all expire together.  Expiries are only ever shortened, so an entry never outlives the
+// expiry it was set with.
+type expiryJitter struct {
+	percent float64       // up to this percent of the expiry
+	max     time.Duration // up to this absolute amount
+}
+
+func newExpiryJitter(opts Options) expiryJitter {
+	return expiryJitter{
+		percent: opts[optionWithExpiryJitterPercent].(float64),
+		max:     opts[optionWithExpiryJitter].(time.Duration),
+	}
+}
+
+// enabled - is there any jitter to apply
+func (j expiryJitter) enabled() bool {
+	return j.percent > 0 || j.max > 0
+}
+
+// apply - exp shortened by a random number of whole seconds, up to the larger of the percent and max jitter.  The
+// stores keep expiries in seconds, so the result is never under a second, and expiries under 2 seconds (as well as
+// FOREVER and DEFAULT) are returned as is.
+func (j expiryJitter) apply(exp time.Duration) time.Duration {
+	if !j.enabled() || exp < 2*time.Second {
+		return exp
+	}
+	spread := time.Duration(float64(exp) * j.percent / 100)
+	if j.max > spread {
+		spread = j.max
+	}
+	exp = exp.Truncate(time.Second)
+	if spread > exp-time.Second {
+		spread = exp - time.Second
+	}
+	seconds := int64(spread / time.Second)
+	if seconds <= 0 {
+		return exp
+	}
+	return exp - time.Duration(rand.Int63n(seconds+1))*time.Second
+}
+
+// WithExactExpiry - don't apply the cache's (or store's) expiry jitter to this entry (see WithExpiryJitter)
+func WithExactExpiry() EntryOption {
+	return func(o *entryOptions) {
+		o.exactExpiry = true
+	}
+}
+
+// entryOptionStore - a store that takes per entry options (e.g. an InMemoryStore)
+type entryOptionStore interface {
+	SetWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error
+	AddWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error
+	ReplaceWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error
+}
+
+// entryExpiry - the entry's expiry with the cache's jitter applied (unless it's WithExactExpiry), and the options for
+// an entryOptionStore.  The store is told the expiry is exact when the entry opted out, or when the cache has
+// already applied its own jitter, so it's never jittered twice.
+func (c *GenericCache) entryExpiry(exp time.Duration, opts entryOptions) (time.Duration, []EntryOption) {
+	if opts.exactExpiry {
+		return exp, []EntryOption{WithExactExpiry()}
+	}
+	if c.jitter.enabled() {
+		return c.jitter.apply(exp), []EntryOption{WithExactExpiry()}
+	}
+	return exp, nil
+}
+
+// entryJitter - a GenericCacheEntry from NewGenericCacheEntry has already been jittered, so when it's set with the
+// same exp it was made with, its TTL is shortened by the same jitter (as an exact expiry) instead of being jittered
+// again, and the entry and the store agree on when it expires.  Any other exp is jittered as usual.
+func entryJitter(data interface{}, exp time.Duration, opts entryOptions) (time.Duration, entryOptions) {
+	e, ok := data.(GenericCacheEntry)
+	if !ok || e.jitter <= 0 || exp != e.exp || opts.exactExpiry {
+		return exp, opts
+	}
+	opts.exactExpiry = true
+	return exp.Truncate(time.Second) - e.jitter, opts
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestExpiryJitter(t *testing.T) {
+	j := expiryJitter{max: time.Minute}
+	for _, exp := range []time.Duration{persistence.FOREVER, persistence.DEFAULT, time.Second} {
+		if got := j.apply(exp); got != exp {
+			t.Errorf("expected %v to be left as is, got %v", exp, got)
+		}
+	}
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		got := j.apply(10 * time.Minute)
+		if got > 10*time.Minute || got < 9*time.Minute || got%time.Second != 0 {
+			t.Fatalf("expected whole seconds in [9m, 10m], got %v", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("expected the expiries to be spread out, got %d distinct values", len(seen))
+	}
+	pct := expiryJitter{percent: 50}
+	for i := 0; i < 200; i++ {
+		if got := pct.apply(10 * time.Second); got < 5*time.Second || got > 10*time.Second {
+			t.Fatalf("expected [5s, 10s], got %v", got)
+		}
+		if got := pct.apply(2 * time.Second); got < time.Second {
+			t.Fatalf("expected at least a second, got %v", got)
+		}
+	}
+}
+
+// expiries - the distinct ExpiresAt (relative to now) of n entries set with f
+func expiries(t *testing.T, store *InMemoryStore, n int, f func(key string) error) map[int64]bool {
+	now := time.Now().Unix()
+	seen := map[int64]bool{}
+	for i := 0; i < n; i++ {
+		key := "jitter-" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		if err := f(key); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var entry GenericCacheEntry
+		if err := store.Get(key, &entry); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		seen[entry.ExpiresAt-now] = true
+	}
+	return seen
+}
+
+func TestInMemoryStore_ExpiryJitter(t *testing.T) {
+	store, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithExpiryJitter(10*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	seen := expiries(t, store, 100, func(key string) error {
+		return store.Set(key, "v", persistence.DEFAULT)
+	})
+	if len(seen) < 10 {
+		t.Errorf("expected jittered expiries, got %v", seen)
+	}
+	for d := range seen {
+		if d > 3600 || d < 3000 {
+			t.Errorf("expected expiries within 10m under the DefaultExp, got %ds", d)
+		}
+	}
+	seen = expiries(t, store, 50, func(key string) error {
+		return store.SetWithOptions(key, "v", time.Hour, WithExactExpiry())
+	})
+	if len(seen) > 2 {
+		t.Errorf("expected exact expiries with WithExactExpiry, got %v", seen)
+	}
+	if entry, _ := store.NewGenericCacheEntry("v", 0); entry.ExpiresAt-entry.TimeAdded > 3600 {
+		t.Errorf("expected NewGenericCacheEntry to be jittered, got %ds", entry.ExpiresAt-entry.TimeAdded)
+	}
+}
+
+func TestGenericCache_ExpiryJitter(t *testing.T) {
+	// the store's jitter would make the exact expiries inexact if the cache didn't tell it they're exact
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithExpiryJitter(10*time.Minute))
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, 3600, []byte("test"), false, WithExpiryJitterPercent(10))
+	seen := expiries(t, store, 100, func(key string) error {
+		return c.Set(key, "v", 0)
+	})
+	if len(seen) < 10 {
+		t.Errorf("expected jittered expiries, got %v", seen)
+	}
+	for d := range seen {
+		if d > 3600 || d < 3240 {
+			t.Errorf("expected expiries within 10%% under the DefaultExp (and jittered once), got %ds", d)
+		}
+	}
+	seen = expiries(t, store, 50, func(key string) error {
+		return c.SetWithOptions(key, "v", time.Hour, WithExactExpiry())
+	})
+	if len(seen) > 2 {
+		t.Errorf("expected exact expiries with WithExactExpiry, got %v", seen)
+	}
+	entry := c.NewGenericCacheEntry("v", time.Hour, WithExactExpiry())
+	if entry.ExpiresAt-entry.TimeAdded != 3600 {
+		t.Errorf("expected an exact expiry, got %ds", entry.ExpiresAt-entry.TimeAdded)
+	}
+}
+
+func TestGenericCache_ExpiryJitterEntries(t *testing.T) {
+	// an entry from NewGenericCacheEntry is already jittered, so its TTL is shortened by the same jitter
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithExpiryJitterPercent(50))
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, 3600, []byte("test"), false, WithExpiryJitterPercent(50))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("entry-%d", i)
+		entry := c.NewGenericCacheEntry("v", time.Hour)
+		if err := c.Set(key, entry, time.Hour); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var got GenericCacheEntry
+		if err := store.Get(key, &got); err != nil || got.ExpiresAt != entry.ExpiresAt {
+			t.Fatalf("expected the store to keep the entry's expiry %d, got %d - %v", entry.ExpiresAt, got.ExpiresAt, err)
+		}
+	}
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	rc := NewCacheWithPool(NewRedisStore(pool, time.Hour), Writable, L2, sharedSecret, 3600, []byte("test"), false, WithExpiryJitterPercent(50))
+	defer rc.Close()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("entry-%d", i)
+		entry := rc.NewGenericCacheEntry("v", time.Hour)
+		if err := rc.Set(key, entry, time.Hour); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ttl, until := m.TTL(key), time.Until(time.Unix(entry.ExpiresAt, 0)); ttl-until > time.Second || until-ttl > time.Second {
+			t.Fatalf("expected the key's TTL to match the entry's expiry, got %v and %v", ttl, until)
+		}
+	}
+
+	// an explicit exp still wins, e.g. for an entry made with another exp or an old (or expired) entry that's stored
+	// again
+	exactStore, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	exact := NewCacheWithPool(exactStore, Writable, L1, sharedSecret, 3600, []byte("exact"), false)
+	for _, entry := range []GenericCacheEntry{
+		c.NewGenericCacheEntry("v", time.Hour),
+		{Data: "v", TimeAdded: time.Now().Unix() - 20, ExpiresAt: time.Now().Unix() - 10},
+		{Data: "v", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	} {
+		if err := exact.Set("old", entry, 10*time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var got GenericCacheEntry
+		exactStore.Get("old", &got)
+		if expected := time.Now().Add(10 * time.Minute).Unix(); got.ExpiresAt < expected-1 || got.ExpiresAt > expected {
+			t.Errorf("expected the entry to expire at %d, got %d", expected, got.ExpiresAt)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// dataKeyLen - the len of data keys generated by NewWrappedDataKey
+	dataKeyLen = 32
+)
+
+// KeyProvider - supplies the key used to encrypt/decrypt cache entries.  Implementations must be safe for concurrent use
+// and the key returned must have a min len of 16.
+type KeyProvider interface {
+	// Key - return the current key
+	Key() ([]byte, error)
+}
+
+// staticKeyProvider - a KeyProvider for a key that never changes
+type staticKeyProvider struct {
+	key []byte
+}
+
+// NewStaticKeyProvider - creates a KeyProvider that always returns the same key (this is what a GenericCache uses
+// for its sharedSecret when no KeyProvider is given)
+func NewStaticKeyProvider(key []byte) KeyProvider {
+	tmp := make([]byte, len(key))
+	copy(tmp, key)
+	return &staticKeyProvider{key: tmp}
+}
+
+// Key - return the key
+func (p *staticKeyProvider) Key() ([]byte, error) {
+	return p.key, nil
+}
+
+// EnvKeyProvider - reads the key from an env var every time it's requested
+type EnvKeyProvider struct {
+	name string
+}
+
+// NewEnvKeyProvider - creates a KeyProvider for the env var name
+func NewEnvKeyProvider(name string) *EnvKeyProvider {
+	return &EnvKeyProvider{name: name}
+}
+
+// Key - return the current value of the env var
+func (p *EnvKeyProvider) Key() ([]byte, error) {
+	v, ok := os.LookupEnv(p.name)
+	if !ok || len(v) == 0 {
+		return nil, fmt.Errorf("EnvKeyProvider.Key: env %s is not defined", p.name)
+	}
+	return []byte(v), nil
+}
+
+// FileKeyProvider - reads the key from a file (like a K8s secret volume mount) and reloads it when the file changes.
+// The file is checked for changes at most once per reloadInterval.  If a reload fails, the last good key is used.
+type FileKeyProvider struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu        sync.Mutex
+	key       []byte
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// NewFileKeyProvider - creates a KeyProvider for the file at path.  A reloadInterval of 0 disables reloading.
+func NewFileKeyProvider(path string, reloadInterval time.Duration) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{
+		path:           path,
+		reloadInterval: reloadInterval,
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Key - return the current key, reloading it from the file if it's changed
+func (p *FileKeyProvider) Key() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reloadInterval > 0 && time.Since(p.lastCheck) >= p.reloadInterval {
+		if err := p.reload(); err != nil && p.key == nil {
+			return nil, err
+		}
+	}
+	return p.key, nil
+}
+
+// reload - read the file if it's changed since the last read (callers must hold p.mu or own p)
+func (p *FileKeyProvider) reload() error {
+	p.lastCheck = time.Now()
+	// Stat follows symlinks, so this sees the atomic ..data swap that K8s does when updating a secret volume
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("FileKeyProvider.reload: can't stat %s: %s", p.path, err.Error())
+	}
+	if p.key != nil && fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("FileKeyProvider.reload: can't read %s: %s", p.path, err.Error())
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return fmt.Errorf("FileKeyProvider.reload: %s is empty", p.path)
+	}
+	p.key = key
+	p.modTime = fi.ModTime()
+	p.size = fi.Size()
+	return nil
+}
+
+// EnvelopeKeyProvider - supplies a per-cache data key which is kept wrapped (encrypted) by a master key.  Only the
+// wrapped data key needs to be stored with the cache's config, and the master key can come from any KeyProvider.
+// The data key is unwrapped again whenever the master key changes.
+type EnvelopeKeyProvider struct {
+	master  KeyProvider
+	wrapped []byte
+
+	mu        sync.Mutex
+	masterKey []byte
+	dataKey   []byte
+}
+
+// NewEnvelopeKeyProvider - creates a KeyProvider for the wrappedDataKey (see NewWrappedDataKey)
+func NewEnvelopeKeyProvider(master KeyProvider, wrappedDataKey []byte) (*EnvelopeKeyProvider, error) {
+	p := &EnvelopeKeyProvider{
+		master:  master,
+		wrapped: wrappedDataKey,
+	}
+	if _, err := p.Key(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Key - return the unwrapped data key
+func (p *EnvelopeKeyProvider) Key() ([]byte, error) {
+	masterKey, err := p.master.Key()
+	if err != nil {
+		return nil, fmt.Errorf("EnvelopeKeyProvider.Key: can't get master key: %s", err.Error())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dataKey != nil && bytes.Equal(masterKey, p.masterKey) {
+		return p.dataKey, nil
+	}
+	dataKey, err := unwrapDataKey(masterKey, p.wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("EnvelopeKeyProvider.Key: can't unwrap data key: %s", err.Error())
+	}
+	p.masterKey = masterKey
+	p.dataKey = dataKey
+	return dataKey, nil
+}
+
+// NewWrappedDataKey - generates a random data key and returns it wrapped by the master key
+func NewWrappedDataKey(master KeyProvider) ([]byte, error) {
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("cache.NewWrappedDataKey: can't generate data key: %s", err.Error())
+	}
+	return WrapDataKey(master, dataKey)
+}
+
+// WrapDataKey - wraps the dataKey with the master key
+func WrapDataKey(master KeyProvider, dataKey []byte) ([]byte, error) {
+	masterKey, err := master.Key()
+	if err != nil {
+		return nil, fmt.Errorf("cache.WrapDataKey: can't get master key: %s", err.Error())
+	}
+	return wrapDataKey(masterKey, dataKey)
+}
+
+// RewrapDataKey - unwraps the data key with the old master key and wraps it with the new one (used when rotating
+// the master key, so the cache's data key and its entries don't change)
+func RewrapDataKey(oldMaster KeyProvider, newMaster KeyProvider, wrappedDataKey []byte) ([]byte, error) {
+	oldKey, err := oldMaster.Key()
+	if err != nil {
+		return nil, fmt.Errorf("cache.RewrapDataKey: can't get old master key: %s", err.Error())
+	}
+	dataKey, err := unwrapDataKey(oldKey, wrappedDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("cache.RewrapDataKey: can't unwrap data key: %s", err.Error())
+	}
+	return WrapDataKey(newMaster, dataKey)
+}
+
+// keyWrapAEAD - AES-256-GCM using a key derived from the master key
+func keyWrapAEAD(masterKey []byte) (cipher.AEAD, error) {
+	if len(masterKey) < 16 {
+		return nil, fmt.Errorf("cache.keyWrapAEAD: master key is too short - must be a min len of 16")
+	}
+	kek := sha256.Sum256(masterKey)
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapDataKey - returns nonce + sealed data key
+func wrapDataKey(masterKey []byte, dataKey []byte) ([]byte, error) {
+	aead, err := keyWrapAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// unwrapDataKey - the inverse of wrapDataKey
+func unwrapDataKey(masterKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := keyWrapAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("cache.unwrapDataKey: wrapped key is too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvKeyProvider_Key(t *testing.T) {
+	const envName = "GO_CACHE_TEST_KEY"
+	os.Unsetenv(envName)
+	kp := NewEnvKeyProvider(envName)
+	if _, err := kp.Key(); err == nil {
+		t.Errorf("expected an error for an undefined env var")
+	}
+	os.Setenv(envName, sharedSecret)
+	defer os.Unsetenv(envName)
+	key, err := kp.Key()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(key) != sharedSecret {
+		t.Errorf("expected %s, got %s", sharedSecret, key)
+	}
+}
+
+func TestFileKeyProvider_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-cache-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(path, []byte("first-secret-16chars\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := NewFileKeyProvider(path, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	key, _ := kp.Key()
+	if string(key) != "first-secret-16chars" {
+		t.Errorf("expected first-secret-16chars, got %s", key)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("second-secret-16chars-longer"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	key, _ = kp.Key()
+	if string(key) != "second-secret-16chars-longer" {
+		t.Errorf("expected the reloaded key, got %s", key)
+	}
+
+	// a missing file keeps the last good key
+	os.Remove(path)
+	time.Sleep(5 * time.Millisecond)
+	key, err = kp.Key()
+	if err != nil || string(key) != "second-secret-16chars-longer" {
+		t.Errorf("expected the last good key, got %s - %v", key, err)
+	}
+
+	if _, err := NewFileKeyProvider(path, 0); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestEnvelopeKeyProvider_Key(t *testing.T) {
+	master := NewStaticKeyProvider([]byte("master-secret-must-be-16"))
+	wrapped, err := NewWrappedDataKey(master)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	kp, err := NewEnvelopeKeyProvider(master, wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dataKey, err := kp.Key()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dataKey) != dataKeyLen {
+		t.Errorf("expected a data key len of %d, got %d", dataKeyLen, len(dataKey))
+	}
+
+	// rotating the master key shouldn't change the data key
+	newMaster := NewStaticKeyProvider([]byte("new-master-secret-must-be-16"))
+	rewrapped, err := RewrapDataKey(master, newMaster, wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rotated, err := NewEnvelopeKeyProvider(newMaster, rewrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rotatedKey, _ := rotated.Key()
+	if string(rotatedKey) != string(dataKey) {
+		t.Errorf("expected the same data key after rewrapping")
+	}
+
+	if _, err := NewEnvelopeKeyProvider(newMaster, wrapped); err == nil {
+		t.Errorf("expected an error unwrapping with the wrong master key")
+	}
+}
+
+func TestGenericCache_WithKeyProvider(t *testing.T) {
+	master := NewStaticKeyProvider([]byte("master-secret-must-be-16"))
+	wrapped, err := NewWrappedDataKey(master)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	kp, err := NewEnvelopeKeyProvider(master, wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	store, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), true, WithKeyProvider(kp))
+	entry := c.NewGenericCacheEntry("encrypted-data", time.Minute)
+	if err := c.Set("value", entry, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := GenericCacheEntry{}
+	if err := c.Get("value", &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Data != "encrypted-data" {
+		t.Errorf("expected encrypted-data, got %v", got.Data)
+	}
+
+	// a cache using the sharedSecret can't read the entry
+	other := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), true)
+	got = GenericCacheEntry{}
+	if err := other.Get("value", &got); err == nil && got.Data == "encrypted-data" {
+		t.Errorf("expected the entry to be unreadable with a different key")
+	}
+}
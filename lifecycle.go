@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// defaultCloseTimeout - how long Close waits for background goroutines and in use connections
+	defaultCloseTimeout = 5 * time.Second
+	// poolDrainInterval - how often ShutdownPool checks for connections that are still in use
+	poolDrainInterval = 10 * time.Millisecond
+)
+
+// redisPools - the pools of the RedisStores made by this package, so they can be closed with their GenericCaches
+// (RedisStore doesn't expose its pool), and anything else that has to be closed with a pool (e.g. the sentinel
+// connections of NewSentinelPool).  A store is forgotten when its pool is closed.
+var redisPools = struct {
+	sync.Mutex
+	byStore map[*persistence.RedisStore]*storePool
+	closers map[*redis.Pool][]func() error
+}{
+	byStore: map[*persistence.RedisStore]*storePool{},
+	closers: map[*redis.Pool][]func() error{},
+}
+
+// sharedStores - how many GenericCaches are using each store that's shut down with them (e.g. an InMemoryStore), so
+// it's only shut down with the last of them
+var sharedStores = struct {
+	sync.Mutex
+	caches map[interface{}]int
+}{
+	caches: map[interface{}]int{},
+}
+
+// storePool - the pool of a RedisStore made by NewRedisStore, and how many GenericCaches are using the store
+type storePool struct {
+	pool   *redis.Pool
+	caches int
+}
+
+// NewRedisStore - creates a RedisStore using the pool.  The GenericCaches using the store own the pool: it's closed
+// when the last of them is closed (or when you close it with ClosePool/ShutdownPool).
+func NewRedisStore(pool *redis.Pool, defaultExpiration time.Duration) *persistence.RedisStore {
+	store := persistence.NewRedisCacheWithPool(pool, defaultExpiration)
+	redisPools.Lock()
+	defer redisPools.Unlock()
+	redisPools.byStore[store] = &storePool{pool: pool}
+	return store
+}
+
+// acquireStore - count a GenericCache using the store, when it's a store with a Shutdown or a RedisStore made by
+// NewRedisStore
+func acquireStore(store interface{}) {
+	if _, ok := store.(interface{ Shutdown(context.Context) error }); ok {
+		sharedStores.Lock()
+		defer sharedStores.Unlock()
+		sharedStores.caches[store]++
+		return
+	}
+	rs, ok := store.(*persistence.RedisStore)
+	if !ok {
+		return
+	}
+	redisPools.Lock()
+	defer redisPools.Unlock()
+	if sp := redisPools.byStore[rs]; sp != nil {
+		sp.caches++
+	}
+}
+
+// releaseStore - a GenericCache using the store is closed.  It returns the store's pool when it was the last cache
+// using it (and the store is forgotten), and whether the store was made by NewRedisStore.
+func releaseStore(store *persistence.RedisStore) (pool *redis.Pool, tracked bool) {
+	redisPools.Lock()
+	defer redisPools.Unlock()
+	sp := redisPools.byStore[store]
+	if sp == nil {
+		return nil, false
+	}
+	if sp.caches--; sp.caches > 0 {
+		return nil, true
+	}
+	delete(redisPools.byStore, store)
+	return sp.pool, true
+}
+
+// releaseShared - a GenericCache using the store is closed.  It returns whether it was the last cache using it.
+func releaseShared(store interface{}) bool {
+	sharedStores.Lock()
+	defer sharedStores.Unlock()
+	if sharedStores.caches[store]--; sharedStores.caches[store] > 0 {
+		return false
+	}
+	delete(sharedStores.caches, store)
+	return true
+}
+
+// onPoolClose - register f to be called when the pool is closed with ClosePool/ShutdownPool
+func onPoolClose(pool *redis.Pool, f func() error) {
+	redisPools.Lock()
+	defer redisPools.Unlock()
+	redisPools.closers[pool] = append(redisPools.closers[pool], f)
+}
+
+// redisStorePool - the pool of a RedisStore made by NewRedisStore (nil for other RedisStores)
+func redisStorePool(store *persistence.RedisStore) *redis.Pool {
+	redisPools.Lock()
+	defer redisPools.Unlock()
+	if sp := redisPools.byStore[store]; sp != nil {
+		return sp.pool
+	}
+	return nil
+}
+
+// redisPoolOf - the pool of any RedisStore (e.g. one made by persistence.NewRedisCacheWithPool), for the commands
+// the store doesn't have.  persistence.RedisStore doesn't export its pool, so it's read with reflection (nil if the
+// store has no pool).  Only the pools in redisPools are closed with their stores.
+func redisPoolOf(store *persistence.RedisStore) *redis.Pool {
+	if pool := redisStorePool(store); pool != nil {
+		return pool
+	}
+	field := reflect.ValueOf(store).Elem().FieldByName("pool")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*redis.Pool)(nil)) {
+		return nil
+	}
+	return *(**redis.Pool)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+// closeUnreturnedStore - close the pool of a store made by NewRedisStore that won't be returned to the caller
+func closeUnreturnedStore(store interface{}) {
+	if pool := redisStorePool(store.(*persistence.RedisStore)); pool != nil {
+		ClosePool(pool)
+	}
+}
+
+// ClosePool - ShutdownPool, waiting at most defaultCloseTimeout
+func ClosePool(pool *redis.Pool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return ShutdownPool(ctx, pool)
+}
+
+// ShutdownPool - wait for the pool's connections that are in use to be returned (or for ctx to be done), then close
+// the pool and anything that was made with it (e.g. NewSentinelPool's sentinel connections, and the pool's metrics).
+// Connections that are still in use are closed when they're returned.  It's idempotent.
+func ShutdownPool(ctx context.Context, pool *redis.Pool) error {
+	var err error
+drain:
+	for pool.ActiveCount() > pool.IdleCount() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break drain
+		case <-time.After(poolDrainInterval):
+		}
+	}
+	if closeErr := pool.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	redisPools.Lock()
+	closers := redisPools.closers[pool]
+	delete(redisPools.closers, pool)
+	for store, sp := range redisPools.byStore {
+		if sp.pool == pool {
+			delete(redisPools.byStore, store)
+		}
+	}
+	redisPools.Unlock()
+	for _, closer := range closers {
+		if closeErr := closer(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Close - Shutdown, waiting at most defaultCloseTimeout
+func (c *GenericCache) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown - close the cache's stores (and its ReadCache) once every GenericCache using the store is closed: an
+// InMemoryStore is shut down (its janitor is stopped), and the pool of a RedisStore made by this package (or
+// NewRedisStore) is drained and closed.  The cache's metrics are unregistered.  It's idempotent, and it's bounded by ctx.
+func (c *GenericCache) Shutdown(ctx context.Context) error {
+	c.closer.once.Do(func() {
+		err := c.closeStore(ctx)
+		if c.ReadCache != nil && c.ReadCache != c {
+			if readErr := c.ReadCache.Shutdown(ctx); readErr != nil && err == nil {
+				err = readErr
+			}
+		}
+		c.registry.unregister()
+		if err != nil {
+			c.logError(fmt.Sprintf("GenericCache.Shutdown: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		}
+		c.closer.err = err
+	})
+	return c.closer.err
+}
+
+func (c *GenericCache) closeStore(ctx context.Context) error {
+	switch store := c.Cache.(type) {
+	case interface{ Shutdown(context.Context) error }:
+		if !releaseShared(store) {
+			c.logDebug(fmt.Sprintf("GenericCache.Shutdown: L%v/T%v the store is still used by another GenericCache, so it isn't shut down yet", c.cLevel, c.cType))
+			return nil
+		}
+		return store.Shutdown(ctx)
+	case *persistence.RedisStore:
+		pool, tracked := releaseStore(store)
+		if pool != nil {
+			return ShutdownPool(ctx, pool)
+		}
+		if tracked {
+			c.logDebug(fmt.Sprintf("GenericCache.Shutdown: L%v/T%v the RedisStore is still used by another GenericCache, so its pool isn't closed yet", c.cLevel, c.cType))
+			return nil
+		}
+		c.logDebug(fmt.Sprintf("GenericCache.Shutdown: L%v/T%v the RedisStore's pool wasn't made by this package, so it's not closed", c.cLevel, c.cType))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeConn - a redis.Conn that doesn't connect to anything
+type fakeConn struct {
+	closed bool
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+func (c *fakeConn) Err() error { return nil }
+func (c *fakeConn) Do(string, ...interface{}) (interface{}, error) {
+	return "OK", nil
+}
+func (c *fakeConn) Send(string, ...interface{}) error { return nil }
+func (c *fakeConn) Flush() error                      { return nil }
+func (c *fakeConn) Receive() (interface{}, error)     { return nil, nil }
+
+func newFakePool() (*redis.Pool, *[]*fakeConn) {
+	var conns []*fakeConn
+	return &redis.Pool{
+		MaxIdle: 2,
+		Dial: func() (redis.Conn, error) {
+			c := &fakeConn{}
+			conns = append(conns, c)
+			return c, nil
+		},
+	}, &conns
+}
+
+func TestInMemoryStore_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c, err := NewInMemoryStore(maxEntries, time.Hour, time.Millisecond, false, "", WithSnapshotFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set("key", "value", time.Hour)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-c.janitor.done:
+	default:
+		t.Errorf("expected the janitor to be stopped")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %s", err)
+	}
+	warm, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithSnapshotFile(path))
+	if warm.Len() != 1 {
+		t.Errorf("expected Close to save the snapshot, got %d entries", warm.Len())
+	}
+
+	sharded, _ := NewShardedInMemoryStore(4, maxEntries, time.Hour, time.Millisecond, false, "")
+	if err := sharded.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-sharded.janitor.done:
+	default:
+		t.Errorf("expected the sharded janitor to be stopped")
+	}
+}
+
+func TestShutdownPool(t *testing.T) {
+	pool, conns := newFakePool()
+	closed := false
+	onPoolClose(pool, func() error {
+		closed = true
+		return nil
+	})
+	inUse := pool.Get()
+	inUse.Do("PING")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ShutdownPool(ctx, pool); err != context.DeadlineExceeded {
+		t.Errorf("expected the drain to time out, got %v", err)
+	}
+	if !closed {
+		t.Errorf("expected the pool's closer to be called")
+	}
+	inUse.Close()
+	if !(*conns)[0].closed {
+		t.Errorf("expected a connection returned to the closed pool to be closed")
+	}
+	if err := ClosePool(pool); err != nil {
+		t.Errorf("expected ClosePool to be idempotent, got %s", err)
+	}
+}
+
+func TestGenericCache_Close(t *testing.T) {
+	writePool, _ := newFakePool()
+	readPool, _ := newFakePool()
+	c := NewCacheWithMultiPools(NewRedisStore(writePool, time.Hour), NewRedisStore(readPool, time.Hour), L2, sharedSecret, defExpSeconds, []byte("test"), false)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, pool := range []*redis.Pool{writePool, readPool} {
+		if err := pool.Get().Err(); err == nil {
+			t.Errorf("expected the pool to be closed")
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %s", err)
+	}
+
+	inMem := newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache)
+	if err := inMem.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestGenericCache_CloseSharedStore(t *testing.T) {
+	pool, _ := newFakePool()
+	store := NewRedisStore(pool, time.Hour)
+	first := NewCacheWithPool(store, Writable, L2, sharedSecret, defExpSeconds, []byte("first"), false)
+	second := NewCacheWithPool(store, Writable, L2, sharedSecret, defExpSeconds, []byte("second"), false)
+	if err := first.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		t.Errorf("expected the pool to stay open while the store is used by another cache, got %s", err)
+	}
+	conn.Close()
+	if err := second.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := pool.Get().Err(); err == nil {
+		t.Errorf("expected the pool to be closed with the last cache using the store")
+	}
+	if redisStorePool(store) != nil {
+		t.Errorf("expected the store to be forgotten once its pool is closed")
+	}
+
+	// an InMemoryStore is shut down with the last cache using it too
+	reg := prometheus.NewRegistry()
+	inMemory, err := NewInMemoryStore(maxEntries, time.Hour, time.Minute, true, "shared_store_test", WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	first = NewCacheWithPool(inMemory, Writable, L1, sharedSecret, defExpSeconds, []byte("first"), false)
+	second = NewCacheWithPool(inMemory, Writable, L1, sharedSecret, defExpSeconds, []byte("second"), false)
+	if err := first.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := gathered(t, reg); got["go_cache_shared_store_test"] != 1 {
+		t.Errorf("expected the store to stay open while it's used by another cache, got %v", got)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := gathered(t, reg); len(got) != 0 {
+		t.Errorf("expected the store to be shut down with the last cache using it, got %v", got)
+	}
+}
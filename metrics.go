@@ -1,8 +1,9 @@
 package cache
 
 import (
-	"fmt"
-	"time"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -12,67 +13,276 @@ const (
 	subsystem = "go_cache"
 )
 
-func incrementCounterVec(c *prometheus.CounterVec, statusCode int) {
-	if c != nil {
-		c.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+// metricsRegistry - where the metrics of a cache or store are registered (see WithRegisterer, WithMetricNamespace and
+// WithConstLabels), and what it registered, so Close can unregister them.  The metric vecs are shared by every cache
+// or store registering on the same registerer with the same namespace and const labels (they're told apart by their
+// labels), and a vec is only unregistered once all of them have been closed.  So are the gauges and counters on the
+// default registerer (see registerFunc).
+type metricsRegistry struct {
+	registerer  prometheus.Registerer
+	namespace   string
+	constLabels prometheus.Labels
+	// explicit - the registerer was passed WithRegisterer, so a store's gauges are its own and a clash is returned
+	explicit bool
+
+	mu         sync.Mutex
+	collectors []prometheus.Collector
+	series     []sharedSeries
+	funcs      []sharedVecKey
+}
+
+// newMetricsRegistry - the registry for the options (prometheus.DefaultRegisterer unless WithRegisterer)
+func newMetricsRegistry(opts Options) *metricsRegistry {
+	r := &metricsRegistry{
+		namespace:   opts[optionWithMetricNamespace].(string),
+		constLabels: opts[optionWithConstLabels].(prometheus.Labels),
+	}
+	r.registerer, r.explicit = opts[optionWithRegisterer].(prometheus.Registerer)
+	if r.registerer == nil {
+		r.registerer, r.explicit = prometheus.DefaultRegisterer, false
 	}
+	return r
 }
 
-func initCounterVec(m *prometheus.CounterVec, name string, help string) *prometheus.CounterVec {
-	if m == nil {
-		m = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Subsystem: subsystem,
-				Name:      name,
-				Help:      help,
-			},
-			[]string{"status_code"},
-		)
-		if err := prometheus.Register(m); err != nil {
-			logrus.Infof("%s could not be registered: " + name + err.Error())
+// deletableVec - the metric vecs (CounterVec, SummaryVec and HistogramVec)
+type deletableVec interface {
+	prometheus.Collector
+	DeleteLabelValues(lvs ...string) bool
+}
+
+type sharedVecKey struct {
+	registerer  prometheus.Registerer
+	fqName      string
+	constLabels string
+}
+
+// sharedVec - a registered vec, and how many caches and stores use each of its series
+type sharedVec struct {
+	vec    deletableVec
+	series map[string]int
+}
+
+// sharedSeries - a series of a sharedVec used by a registry
+type sharedSeries struct {
+	key         sharedVecKey
+	labelValues []string
+}
+
+// sharedFunc - a GaugeFunc or CounterFunc on the default registerer, and the values of the caches and stores using
+// it (it reports their sum)
+type sharedFunc struct {
+	collector prometheus.Collector
+	values    map[*metricsRegistry]func() float64
+}
+
+var (
+	sharedVecsMu sync.Mutex
+	sharedVecs   = map[sharedVecKey]*sharedVec{}
+	sharedFuncs  = map[sharedVecKey]*sharedFunc{}
+)
+
+// sum - the sum of the values (which are read without holding sharedVecsMu, since they may lock their store)
+func (s *sharedFunc) sum() float64 {
+	sharedVecsMu.Lock()
+	values := make([]func() float64, 0, len(s.values))
+	for _, v := range s.values {
+		values = append(values, v)
+	}
+	sharedVecsMu.Unlock()
+	var total float64
+	for _, v := range values {
+		total += v()
+	}
+	return total
+}
 
+func (k sharedSeries) id() string {
+	return strings.Join(k.labelValues, "\xff")
+}
+
+func (r *metricsRegistry) key(name string) sharedVecKey {
+	labels := make([]string, 0, len(r.constLabels))
+	for k, v := range r.constLabels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return sharedVecKey{
+		registerer:  r.registerer,
+		fqName:      prometheus.BuildFQName(r.namespace, subsystem, name),
+		constLabels: strings.Join(labels, ","),
+	}
+}
+
+// sharedVecFor - the vec called name on r's registerer (registered with newVec if it isn't yet), using the series
+// for labelValues
+func sharedVecFor[V deletableVec](r *metricsRegistry, name string, newVec func() V, labelValues []string) V {
+	sharedVecsMu.Lock()
+	defer sharedVecsMu.Unlock()
+	key := r.key(name)
+	shared, ok := sharedVecs[key]
+	if !ok {
+		var vec deletableVec = newVec()
+		if err := r.registerer.Register(vec); err != nil {
+			if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+				if existing, ok := are.ExistingCollector.(V); ok {
+					vec = existing
+				}
+			} else {
+				logrus.Warnf("%s could not be registered: %s", key.fqName, err.Error())
+			}
 		} else {
-			logrus.Infof("%s registered.", name)
+			logrus.Infof("%s registered.", key.fqName)
 		}
+		shared = &sharedVec{vec: vec, series: map[string]int{}}
+		sharedVecs[key] = shared
+	}
+	series := sharedSeries{key: key, labelValues: labelValues}
+	shared.series[series.id()]++
+	r.mu.Lock()
+	r.series = append(r.series, series)
+	r.mu.Unlock()
+	if vec, ok := shared.vec.(V); ok {
+		return vec
 	}
-	return m
-}
-func initSummary(m prometheus.Summary, name string, help string) prometheus.Summary {
-	if m == nil {
-		m = prometheus.NewSummary(
-			prometheus.SummaryOpts{
-				Subsystem: subsystem,
-				Name:      name,
-				Help:      help,
+	// another kind of collector is registered with this name, so this one isn't exported
+	return newVec()
+}
+
+// counter - the series for labelValues of the CounterVec called name
+func (r *metricsRegistry) counter(name string, help string, labels []string, labelValues ...string) prometheus.Counter {
+	return sharedVecFor(r, name, func() *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: r.namespace, Subsystem: subsystem, Name: name, Help: help, ConstLabels: r.constLabels}, labels)
+	}, labelValues).WithLabelValues(labelValues...)
+}
+
+// summary - the series for labelValues of the SummaryVec called name
+func (r *metricsRegistry) summary(name string, help string, labels []string, labelValues ...string) prometheus.Observer {
+	return sharedVecFor(r, name, func() *prometheus.SummaryVec {
+		return prometheus.NewSummaryVec(prometheus.SummaryOpts{Namespace: r.namespace, Subsystem: subsystem, Name: name, Help: help, ConstLabels: r.constLabels}, labels)
+	}, labelValues).WithLabelValues(labelValues...)
+}
+
+// histogram - the series for labelValues of the HistogramVec called name
+func (r *metricsRegistry) histogram(name string, help string, buckets []float64, labels []string, labelValues ...string) prometheus.Observer {
+	return sharedVecFor(r, name, func() *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: r.namespace, Subsystem: subsystem, Name: name, Help: help, ConstLabels: r.constLabels, Buckets: buckets}, labels)
+	}, labelValues).WithLabelValues(labelValues...)
+}
+
+// gaugeFunc - register a GaugeFunc called name for this cache or store (see registerFunc)
+func (r *metricsRegistry) gaugeFunc(gaugeFunc func() float64, name string, help string) error {
+	return r.registerFunc(name, func(f func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace:   r.namespace,
+				Subsystem:   subsystem,
+				Name:        name,
+				Help:        help,
+				ConstLabels: r.constLabels,
 			},
+			f,
 		)
-		if err := prometheus.Register(m); err != nil {
-			logrus.Infof("%s could not be registered: " + name + err.Error())
-		} else {
-			logrus.Infof("%s registered.", name)
-		}
-	}
-	return m
+	}, gaugeFunc)
+}
+
+// counterFunc - register a CounterFunc called name for this cache or store (see registerFunc)
+func (r *metricsRegistry) counterFunc(counterFunc func() float64, name string, help string) error {
+	return r.registerFunc(name, func(f func() float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Namespace:   r.namespace,
+				Subsystem:   subsystem,
+				Name:        name,
+				Help:        help,
+				ConstLabels: r.constLabels,
+			},
+			f,
+		)
+	}, counterFunc)
 }
 
-func observeSummary(s prometheus.Summary, start time.Time) {
-	elapsed := float64(time.Since(start)) / float64(time.Second)
-	s.Observe(elapsed)
+// registerFunc - register the GaugeFunc or CounterFunc called name (made by newFunc) for value.  On an explicit
+// registerer (see WithRegisterer) it belongs to this cache or store alone, so a clash is returned.  On the default
+// registerer the caches and stores with the same name (e.g. stores with the same metricLabel) reuse it, and it
+// reports the sum of their values; a clash with a collector that isn't this package's is returned.
+func (r *metricsRegistry) registerFunc(name string, newFunc func(func() float64) prometheus.Collector, value func() float64) error {
+	if r.explicit {
+		return r.register(name, newFunc(value))
+	}
+	sharedVecsMu.Lock()
+	defer sharedVecsMu.Unlock()
+	key := r.key(name)
+	shared, ok := sharedFuncs[key]
+	if !ok {
+		shared = &sharedFunc{values: map[*metricsRegistry]func() float64{}}
+		shared.collector = newFunc(shared.sum)
+		if err := r.registerer.Register(shared.collector); err != nil {
+			logrus.Warnf("%s could not be registered: %s", name, err.Error())
+			return err
+		}
+		logrus.Infof("%s registered.", name)
+		sharedFuncs[key] = shared
+	}
+	shared.values[r] = value
+	r.mu.Lock()
+	r.funcs = append(r.funcs, key)
+	r.mu.Unlock()
+	return nil
 }
 
-func initGaugeWithFunc(gaugeFunc func() float64, name string, help string) error {
-	gf := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Subsystem: subsystem,
-			Name:      name,
-			Help:      help,
-		},
-		gaugeFunc,
-	)
-	if err := prometheus.Register(gf); err != nil {
-		logrus.Infof("%s could not be registered: %s", name, err.Error())
+// register - register a collector that belongs to this cache or store alone, returning a clash
+func (r *metricsRegistry) register(name string, c prometheus.Collector) error {
+	if err := r.registerer.Register(c); err != nil {
+		logrus.Warnf("%s could not be registered: %s", name, err.Error())
 		return err
 	}
 	logrus.Infof("%s registered.", name)
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
 	return nil
 }
+
+// unregister - unregister the gauges, and drop the series of the shared vecs and the values of the shared gauges
+// (unregistering the ones that aren't used anymore).  It's idempotent, and a nil registry (a cache or store without
+// metrics) has nothing to unregister.
+func (r *metricsRegistry) unregister() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	collectors, series, funcs := r.collectors, r.series, r.funcs
+	r.collectors, r.series, r.funcs = nil, nil, nil
+	r.mu.Unlock()
+	for _, c := range collectors {
+		r.registerer.Unregister(c)
+	}
+	sharedVecsMu.Lock()
+	defer sharedVecsMu.Unlock()
+	for _, key := range funcs {
+		shared, ok := sharedFuncs[key]
+		if !ok {
+			continue
+		}
+		if delete(shared.values, r); len(shared.values) == 0 {
+			r.registerer.Unregister(shared.collector)
+			delete(sharedFuncs, key)
+		}
+	}
+	for _, s := range series {
+		shared, ok := sharedVecs[s.key]
+		if !ok {
+			continue
+		}
+		id := s.id()
+		if shared.series[id]--; shared.series[id] > 0 {
+			continue
+		}
+		delete(shared.series, id)
+		shared.vec.DeleteLabelValues(s.labelValues...)
+		if len(shared.series) == 0 {
+			r.registerer.Unregister(shared.vec)
+			delete(sharedVecs, s.key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gathered - the number of series of each metric family in the registry
+func gathered(t *testing.T, reg *prometheus.Registry) map[string]int {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := map[string]int{}
+	for _, f := range families {
+		got[f.GetName()] = len(f.GetMetric())
+	}
+	return got
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, "registry_test", WithRegisterer(reg), WithMetricNamespace("app"),
+		WithConstLabels(prometheus.Labels{"instance": "a"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, "registry_test", WithRegisterer(reg), WithMetricNamespace("app"),
+		WithConstLabels(prometheus.Labels{"instance": "b"}))
+	if err != nil {
+		t.Fatalf("expected different const labels not to clash, got %s", err)
+	}
+	if _, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, "registry_test", WithRegisterer(reg), WithMetricNamespace("app"),
+		WithConstLabels(prometheus.Labels{"instance": "a"})); err == nil {
+		t.Errorf("expected a clash on an explicit registerer to be an error")
+	}
+	var v int
+	a.Get("missing", &v)
+	b.Get("missing", &v)
+	got := gathered(t, reg)
+	if got["app_go_cache_registry_test"] != 2 || got["app_go_cache_inmemory_cache_misses_total"] != 2 {
+		t.Errorf("expected the series of both stores, got %v", got)
+	}
+
+	a.Close()
+	got = gathered(t, reg)
+	if got["app_go_cache_registry_test"] != 1 || got["app_go_cache_inmemory_cache_misses_total"] != 1 {
+		t.Errorf("expected only b's series after a is closed, got %v", got)
+	}
+	c := NewCacheWithPool(b, Writable, L1, sharedSecret, defExpSeconds, []byte("registry_test"), false, WithOperationMetrics(true),
+		WithRegisterer(reg), WithMetricNamespace("app"))
+	c.Get("missing", &v)
+	if got = gathered(t, reg); got["app_go_cache_generic_cache_misses_total"] == 0 {
+		t.Errorf("expected the cache's metrics to be registered, got %v", got)
+	}
+	c.Close()
+	b.Close()
+	if got = gathered(t, reg); len(got) != 0 {
+		t.Errorf("expected everything to be unregistered once all are closed, got %v", got)
+	}
+}
+
+// defaultGauge - the value of the gauge called name on the default registerer (and whether it's registered)
+func defaultGauge(t *testing.T, name string) (float64, bool) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) == 1 {
+			return f.GetMetric()[0].GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func TestMetricsRegistry_DefaultRegisterer(t *testing.T) {
+	const label = "default_registry_test"
+	a, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, label)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, label)
+	if err != nil {
+		t.Fatalf("expected stores with the same label to share their gauge, got %s", err)
+	}
+	a.Set("1", 1, time.Hour)
+	a.Set("2", 2, time.Hour)
+	b.Set("3", 3, time.Hour)
+	if v, ok := defaultGauge(t, "go_cache_"+label); !ok || v != 3 {
+		t.Errorf("expected the gauge to count the items of both stores (3), got %v", v)
+	}
+	a.Close()
+	if v, ok := defaultGauge(t, "go_cache_"+label); !ok || v != 1 {
+		t.Errorf("expected the gauge to count b's items (1) after a is closed, got %v", v)
+	}
+	b.Close()
+	if _, ok := defaultGauge(t, "go_cache_"+label); ok {
+		t.Errorf("expected the gauge to be unregistered once both stores are closed")
+	}
+
+	// a clash with a collector that isn't a store's is returned
+	const clash = "default_registry_clash_test"
+	help := "Total count the number of items in the in-memory cache for " + clash
+	foreign := prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "go_cache_" + clash, Help: help}, func() float64 { return 0 })
+	prometheus.MustRegister(foreign)
+	defer prometheus.Unregister(foreign)
+	if _, err := NewInMemoryStore(maxEntries, time.Hour, 0, true, clash); err == nil {
+		t.Errorf("expected a clash with a foreign collector to be an error")
+	}
+}
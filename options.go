@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// GetOpts - iterate the inbound Options and return a struct
+func GetOpts(opt ...Option) Options {
+	opts := getDefaultOptions()
+	for _, o := range opt {
+		o(opts)
+	}
+	return opts
+}
+
+// Option - how Options are passed as arguments
+type Option func(Options)
+
+// Options = how options are represented
+type Options map[string]interface{}
+
+func getDefaultOptions() Options {
+	return Options{
+		optionWithKeyProvider:         nil,
+		optionWithSignData:            false,
+		optionWithMaxBytes:            int64(0),
+		optionWithCostFunc:            nil,
+		optionWithEvictionPolicy:      nil,
+		optionWithMaxExpiredPerTick:   defaultMaxExpiredPerTick,
+		optionWithSnapshotFile:        "",
+		optionWithSnapshotLogger:      (*logrus.Entry)(nil),
+		optionWithCopyOnRead:          false,
+		optionWithExpiryJitter:        time.Duration(0),
+		optionWithExpiryJitterPercent: float64(0),
+		optionWithSlidingExpiration:   false,
+		optionWithMaxLifetime:         time.Duration(0),
+		optionWithInvalidationBus:     (*InvalidationBus)(nil),
+		optionWithOperationMetrics:    false,
+		optionWithRegisterer:          nil,
+		optionWithMetricNamespace:     "",
+		optionWithConstLabels:         prometheus.Labels(nil),
+		optionWithPoolMetrics:         false,
+		optionWithLogger:              (*logrus.Entry)(nil),
+	}
+}
+
+const (
+	optionWithKeyProvider         = "optionWithKeyProvider"
+	optionWithSignData            = "optionWithSignData"
+	optionWithMaxBytes            = "optionWithMaxBytes"
+	optionWithCostFunc            = "optionWithCostFunc"
+	optionWithEvictionPolicy      = "optionWithEvictionPolicy"
+	optionWithMaxExpiredPerTick   = "optionWithMaxExpiredPerTick"
+	optionWithSnapshotFile        = "optionWithSnapshotFile"
+	optionWithSnapshotLogger      = "optionWithSnapshotLogger"
+	optionWithCopyOnRead          = "optionWithCopyOnRead"
+	optionWithExpiryJitter        = "optionWithExpiryJitter"
+	optionWithExpiryJitterPercent = "optionWithExpiryJitterPercent"
+	optionWithSlidingExpiration   = "optionWithSlidingExpiration"
+	optionWithMaxLifetime         = "optionWithMaxLifetime"
+	optionWithInvalidationBus     = "optionWithInvalidationBus"
+	optionWithOperationMetrics    = "optionWithOperationMetrics"
+	optionWithRegisterer          = "optionWithRegisterer"
+	optionWithMetricNamespace     = "optionWithMetricNamespace"
+	optionWithConstLabels         = "optionWithConstLabels"
+	optionWithPoolMetrics         = "optionWithPoolMetrics"
+	optionWithLogger              = "optionWithLogger"
+)
+
+// WithKeyProvider optional KeyProvider used to encrypt/decrypt entries (defaults to the sharedSecret)
+func WithKeyProvider(kp KeyProvider) Option {
+	return func(o Options) {
+		o[optionWithKeyProvider] = kp
+	}
+}
+
+// WithSignData optional integrity check: an HMAC is appended to every entry and checked on Get
+func WithSignData(sign bool) Option {
+	return func(o Options) {
+		o[optionWithSignData] = sign
+	}
+}
+
+// WithMaxBytes optional InMemoryStore budget: entries are evicted (oldest first) to keep the total cost of the
+// entries under maxBytes (0 means no budget)
+func WithMaxBytes(maxBytes int64) Option {
+	return func(o Options) {
+		o[optionWithMaxBytes] = maxBytes
+	}
+}
+
+// WithCostFunc optional InMemoryStore cost of an entry in bytes (defaults to the size of the key plus the
+// serialized value).  Only used with WithMaxBytes.
+func WithCostFunc(cost CostFunc) Option {
+	return func(o Options) {
+		o[optionWithCostFunc] = cost
+	}
+}
+
+// WithEvictionPolicy optional InMemoryStore eviction policy: NewLRUPolicy, NewARCPolicy (the default), NewLFUPolicy,
+// NewTinyLFUPolicy or your own PolicyFactory
+func WithEvictionPolicy(newPolicy PolicyFactory) Option {
+	return func(o Options) {
+		o[optionWithEvictionPolicy] = newPolicy
+	}
+}
+
+// WithMaxExpiredPerTick optional InMemoryStore and ArenaStore bound on the number of expired entries the janitor removes per
+// cleanupInterval (defaults to 10000).  Expired entries that are left are removed on later ticks, or when they're
+// read.
+func WithMaxExpiredPerTick(n int) Option {
+	return func(o Options) {
+		o[optionWithMaxExpiredPerTick] = n
+	}
+}
+
+// WithSnapshotFile optional InMemoryStore snapshot file: the store is restored from it when it's created (if it
+// exists), and SaveSnapshot writes it (e.g. on shutdown), so a restarted process starts warm
+func WithSnapshotFile(path string) Option {
+	return func(o Options) {
+		o[optionWithSnapshotFile] = path
+	}
+}
+
+// WithSnapshotLogger optional InMemoryStore logger for errors restoring the WithSnapshotFile snapshot when the store
+// is created (a bad snapshot doesn't keep the store from starting, so without a logger its errors are dropped)
+func WithSnapshotLogger(logger *logrus.Entry) Option {
+	return func(o Options) {
+		o[optionWithSnapshotLogger] = logger
+	}
+}
+
+// WithCopyOnRead optional InMemoryStore isolation: values are deep copied when they're stored and when they're read,
+// so a caller that mutates a slice, map or struct it set or got can't change the cached value for everyone else.
+// Copies cost allocations (see BenchmarkInMemoryGetCopyOnRead), and immutable values (numbers, strings, etc) aren't
+// copied.
+func WithCopyOnRead(copyOnRead bool) Option {
+	return func(o Options) {
+		o[optionWithCopyOnRead] = copyOnRead
+	}
+}
+
+// WithExpiryJitter optional GenericCache and InMemoryStore jitter: every computed expiry (including DefaultExp and
+// NewGenericCacheEntry's) is shortened by a random number of seconds up to max, so entries set together don't all
+// expire together.  Use WithExactExpiry for entries that need their exact expiry.
+func WithExpiryJitter(max time.Duration) Option {
+	return func(o Options) {
+		o[optionWithExpiryJitter] = max
+	}
+}
+
+// WithExpiryJitterPercent optional GenericCache and InMemoryStore jitter as a percent (0-100) of each expiry (see
+// WithExpiryJitter).  When both are set, the larger jitter is used.
+func WithExpiryJitterPercent(percent float64) Option {
+	return func(o Options) {
+		o[optionWithExpiryJitterPercent] = percent
+	}
+}
+
+// WithSlidingExpiration optional GenericCache sliding expiry: an entry's expiry is extended by the expiry it was set
+// with every time it's read (with PEXPIRE in Redis, and in place in an InMemoryStore), so it expires after that long
+// without being read.  Sliding entries are always sealed, and WithEntrySliding overrides this per entry.
+func WithSlidingExpiration(sliding bool) Option {
+	return func(o Options) {
+		o[optionWithSlidingExpiration] = sliding
+	}
+}
+
+// WithMaxLifetime optional GenericCache absolute max lifetime for sliding entries, so an entry that's read often
+// still expires this long after it was set (0, the default, means no max lifetime).  WithEntryMaxLifetime overrides
+// this per entry.
+func WithMaxLifetime(maxLifetime time.Duration) Option {
+	return func(o Options) {
+		o[optionWithMaxLifetime] = maxLifetime
+	}
+}
+
+// WithInvalidationBus optional GenericCache invalidation: the keys the cache writes or deletes (and its Flushes) are
+// published on the bus, so other instances drop them from their local stores.  Use it on the cache that takes the
+// writes (e.g. the L2), not on an L1 that's only filled from it.
+func WithInvalidationBus(bus *InvalidationBus) Option {
+	return func(o Options) {
+		o[optionWithInvalidationBus] = bus
+	}
+}
+
+// WithOperationMetrics optional GenericCache prometheus metrics: hits, misses, errors and latency for every operation,
+// and how long encrypting, decrypting, serializing and deserializing take, labelled by operation (or stage), level,
+// type and key prefix
+func WithOperationMetrics(enabled bool) Option {
+	return func(o Options) {
+		o[optionWithOperationMetrics] = enabled
+	}
+}
+
+// WithRegisterer optional GenericCache and InMemoryStore prometheus registerer for their metrics (defaults to
+// prometheus.DefaultRegisterer).  The metrics are unregistered when the cache or store is closed, and a store's
+// metrics that can't be registered (e.g. a clashing name) make NewInMemoryStore fail instead of sharing a gauge with
+// the stores of the same name (as they do on the default registerer).
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(o Options) {
+		o[optionWithRegisterer] = registerer
+	}
+}
+
+// WithMetricNamespace optional GenericCache and InMemoryStore namespace, prepended to the names of their metrics (e.g.
+// myapp_go_cache_inmemory_cache_hits_total)
+func WithMetricNamespace(namespace string) Option {
+	return func(o Options) {
+		o[optionWithMetricNamespace] = namespace
+	}
+}
+
+// WithConstLabels optional GenericCache and InMemoryStore labels added to all of their metrics, so caches and stores
+// with the same metric names (e.g. two stores with the same metricLabel) don't clash
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o Options) {
+		o[optionWithConstLabels] = labels
+	}
+}
+
+// WithPoolMetrics optional NewSentinelPool, InitRedisCache, InitReadOnlyRedisCache and RedisConnectionInfo.New
+// prometheus metrics for the redis pools they make (see ExportPoolMetrics), labelled with the pool's role (sentinel,
+// redis or read_only)
+func WithPoolMetrics(enabled bool) Option {
+	return func(o Options) {
+		o[optionWithPoolMetrics] = enabled
+	}
+}
+
+// WithLogger optional NewInvalidationBus logger for its publish, subscribe and flush errors (defaults to logrus's
+// standard logger)
+func WithLogger(logger *logrus.Entry) Option {
+	return func(o Options) {
+		o[optionWithLogger] = logger
+	}
+}
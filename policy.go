@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// EvictionPolicy - decides which entries an InMemoryStore keeps when it's full.  Implementations must be safe for
+// concurrent use.
+type EvictionPolicy interface {
+	// Get - get the value for key, recording the access
+	Get(key interface{}) (value interface{}, ok bool)
+	// Peek - get the value for key without recording an access
+	Peek(key interface{}) (value interface{}, ok bool)
+	// Add - add or update the value for key, evicting an entry if the policy is full
+	Add(key, value interface{})
+	// Remove - remove the key
+	Remove(key interface{})
+	// Contains - is the key in the policy (without recording an access)
+	Contains(key interface{}) bool
+	// Keys - all the keys, with the next to be evicted first
+	Keys() []interface{}
+	// Len - the number of entries
+	Len() int
+	// Purge - remove all the entries
+	Purge()
+}
+
+// EvictCallback - called with the entries a policy evicts to make room for new ones (it's not called for Remove
+// or Purge).  It's called while the policy is locked, so it must not call the policy.
+type EvictCallback func(key interface{}, value interface{})
+
+// PolicyFactory - creates an EvictionPolicy that holds at most size entries
+type PolicyFactory func(size int, onEvict EvictCallback) (EvictionPolicy, error)
+
+// policyUpdater - an EvictionPolicy that can replace the value for a key without recording an access (the policies
+// in this package all can).  Update returns false if the key isn't in the policy.
+type policyUpdater interface {
+	Update(key, value interface{}) bool
+}
+
+// slot - holds a value in a simplelru.LRU, so it can be updated without the LRU promoting it
+type slot struct {
+	value interface{}
+}
+
+// lruPolicy - evicts the least recently used entry
+type lruPolicy struct {
+	mu      sync.Mutex
+	size    int
+	lru     *simplelru.LRU
+	onEvict EvictCallback
+}
+
+// NewLRUPolicy - creates a least recently used EvictionPolicy
+func NewLRUPolicy(size int, onEvict EvictCallback) (EvictionPolicy, error) {
+	l, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &lruPolicy{size: size, lru: l, onEvict: onEvict}, nil
+}
+
+func (p *lruPolicy) Get(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.lru.Get(key); ok {
+		return v.(*slot).value, true
+	}
+	return nil, false
+}
+
+func (p *lruPolicy) Peek(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.lru.Peek(key); ok {
+		return v.(*slot).value, true
+	}
+	return nil, false
+}
+
+func (p *lruPolicy) Add(key, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.lru.Get(key); ok {
+		v.(*slot).value = value
+		return
+	}
+	if p.lru.Len() >= p.size {
+		if k, v, ok := p.lru.RemoveOldest(); ok && p.onEvict != nil {
+			p.onEvict(k, v.(*slot).value)
+		}
+	}
+	p.lru.Add(key, &slot{value: value})
+}
+
+func (p *lruPolicy) Update(key, value interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.lru.Peek(key)
+	if ok {
+		v.(*slot).value = value
+	}
+	return ok
+}
+
+func (p *lruPolicy) Remove(key interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lru.Remove(key)
+}
+
+func (p *lruPolicy) Contains(key interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Contains(key)
+}
+
+func (p *lruPolicy) Keys() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Keys()
+}
+
+func (p *lruPolicy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+func (p *lruPolicy) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lru.Purge()
+}
+
+// arcPolicy - Adaptive Replacement Cache: balances between recently used once (t1) and frequently used (t2)
+// entries, using ghost lists of recently evicted keys (b1, b2) to learn the target size of t1 (p).  This is the
+// algorithm of github.com/hashicorp/golang-lru's ARCCache (which was the InMemoryStore's only policy), but it
+// reports its evictions.
+type arcPolicy struct {
+	mu      sync.Mutex
+	size    int
+	p       int
+	t1, t2  *simplelru.LRU
+	b1, b2  *simplelru.LRU
+	onEvict EvictCallback
+}
+
+// NewARCPolicy - creates an Adaptive Replacement Cache EvictionPolicy (the InMemoryStore default)
+func NewARCPolicy(size int, onEvict EvictCallback) (EvictionPolicy, error) {
+	p := &arcPolicy{size: size, onEvict: onEvict}
+	for _, l := range []**simplelru.LRU{&p.t1, &p.t2, &p.b1, &p.b2} {
+		var err error
+		if *l, err = simplelru.NewLRU(size, nil); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *arcPolicy) Get(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// a second access promotes from recent to frequent
+	if v, ok := p.t1.Peek(key); ok {
+		p.t1.Remove(key)
+		p.t2.Add(key, v)
+		return v.(*slot).value, true
+	}
+	if v, ok := p.t2.Get(key); ok {
+		return v.(*slot).value, true
+	}
+	return nil, false
+}
+
+// peek - the key's slot in t1 or t2
+func (p *arcPolicy) peek(key interface{}) (*slot, bool) {
+	if v, ok := p.t1.Peek(key); ok {
+		return v.(*slot), true
+	}
+	if v, ok := p.t2.Peek(key); ok {
+		return v.(*slot), true
+	}
+	return nil, false
+}
+
+func (p *arcPolicy) Peek(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.peek(key); ok {
+		return s.value, true
+	}
+	return nil, false
+}
+
+func (p *arcPolicy) Update(key, value interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.peek(key)
+	if ok {
+		s.value = value
+	}
+	return ok
+}
+
+func (p *arcPolicy) Add(key, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.t1.Contains(key):
+		p.t1.Remove(key)
+		p.t2.Add(key, &slot{value: value})
+		return
+	case p.t2.Contains(key):
+		p.t2.Add(key, &slot{value: value})
+		return
+	case p.b1.Contains(key):
+		// recently evicted from t1, so t1 is too small
+		delta := 1
+		if b1Len, b2Len := p.b1.Len(), p.b2.Len(); b2Len > b1Len {
+			delta = b2Len / b1Len
+		}
+		if p.p += delta; p.p > p.size {
+			p.p = p.size
+		}
+		if p.t1.Len()+p.t2.Len() >= p.size {
+			p.replace(false)
+		}
+		p.b1.Remove(key)
+		p.t2.Add(key, &slot{value: value})
+		return
+	case p.b2.Contains(key):
+		// recently evicted from t2, so t2 is too small
+		delta := 1
+		if b1Len, b2Len := p.b1.Len(), p.b2.Len(); b1Len > b2Len {
+			delta = b1Len / b2Len
+		}
+		if p.p -= delta; p.p < 0 {
+			p.p = 0
+		}
+		if p.t1.Len()+p.t2.Len() >= p.size {
+			p.replace(true)
+		}
+		p.b2.Remove(key)
+		p.t2.Add(key, &slot{value: value})
+		return
+	}
+	if p.t1.Len()+p.t2.Len() >= p.size {
+		p.replace(false)
+	}
+	if p.b1.Len() > p.size-p.p {
+		p.b1.RemoveOldest()
+	}
+	if p.b2.Len() > p.p {
+		p.b2.RemoveOldest()
+	}
+	p.t1.Add(key, &slot{value: value})
+}
+
+// replace - evict from t1 or t2 based on the target size of t1, remembering the key in the ghost list
+func (p *arcPolicy) replace(b2ContainsKey bool) {
+	from, ghost := p.t2, p.b2
+	if t1Len := p.t1.Len(); t1Len > 0 && (t1Len > p.p || (t1Len == p.p && b2ContainsKey)) {
+		from, ghost = p.t1, p.b1
+	}
+	if k, v, ok := from.RemoveOldest(); ok {
+		ghost.Add(k, nil)
+		if p.onEvict != nil {
+			p.onEvict(k, v.(*slot).value)
+		}
+	}
+}
+
+func (p *arcPolicy) Remove(key interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.t1.Remove(key) || p.t2.Remove(key) || p.b1.Remove(key) {
+		return
+	}
+	p.b2.Remove(key)
+}
+
+func (p *arcPolicy) Contains(key interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.t1.Contains(key) || p.t2.Contains(key)
+}
+
+func (p *arcPolicy) Keys() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append(p.t1.Keys(), p.t2.Keys()...)
+}
+
+func (p *arcPolicy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.t1.Len() + p.t2.Len()
+}
+
+func (p *arcPolicy) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.t1.Purge()
+	p.t2.Purge()
+	p.b1.Purge()
+	p.b2.Purge()
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// lfuPolicy - evicts the least frequently used entry (the least recently used one when there's a tie).  Gets, Adds
+// and evictions are O(1).
+type lfuPolicy struct {
+	mu      sync.Mutex
+	size    int
+	items   map[interface{}]*list.Element
+	buckets map[int]*list.List // entries by access count, most recently used at the front
+	minFreq int
+	onEvict EvictCallback
+}
+
+type lfuItem struct {
+	key   interface{}
+	value interface{}
+	freq  int
+}
+
+// NewLFUPolicy - creates a least frequently used EvictionPolicy
+func NewLFUPolicy(size int, onEvict EvictCallback) (EvictionPolicy, error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	return &lfuPolicy{
+		size:    size,
+		items:   map[interface{}]*list.Element{},
+		buckets: map[int]*list.List{},
+		onEvict: onEvict,
+	}, nil
+}
+
+// touch - move the entry to the bucket for its next access count
+func (p *lfuPolicy) touch(e *list.Element) *list.Element {
+	item := e.Value.(*lfuItem)
+	p.unlink(e)
+	item.freq++
+	return p.link(item)
+}
+
+func (p *lfuPolicy) link(item *lfuItem) *list.Element {
+	b, ok := p.buckets[item.freq]
+	if !ok {
+		b = list.New()
+		p.buckets[item.freq] = b
+	}
+	if item.freq < p.minFreq || len(p.items) == 0 {
+		p.minFreq = item.freq
+	}
+	e := b.PushFront(item)
+	p.items[item.key] = e
+	return e
+}
+
+func (p *lfuPolicy) unlink(e *list.Element) {
+	item := e.Value.(*lfuItem)
+	b := p.buckets[item.freq]
+	b.Remove(e)
+	if b.Len() == 0 {
+		delete(p.buckets, item.freq)
+		if p.minFreq == item.freq {
+			p.minFreq++
+		}
+	}
+	delete(p.items, item.key)
+}
+
+// evict - remove the least frequently used entry
+func (p *lfuPolicy) evict() {
+	b, ok := p.buckets[p.minFreq]
+	if !ok {
+		// minFreq is stale after a Remove, so find the real min
+		p.minFreq = -1
+		for freq := range p.buckets {
+			if p.minFreq < 0 || freq < p.minFreq {
+				p.minFreq = freq
+			}
+		}
+		if b, ok = p.buckets[p.minFreq]; !ok {
+			return
+		}
+	}
+	e := b.Back()
+	p.unlink(e)
+	if p.onEvict != nil {
+		item := e.Value.(*lfuItem)
+		p.onEvict(item.key, item.value)
+	}
+}
+
+func (p *lfuPolicy) Get(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.items[key]
+	if !ok {
+		return nil, false
+	}
+	return p.touch(e).Value.(*lfuItem).value, true
+}
+
+func (p *lfuPolicy) Peek(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		return e.Value.(*lfuItem).value, true
+	}
+	return nil, false
+}
+
+func (p *lfuPolicy) Update(key, value interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.items[key]
+	if ok {
+		e.Value.(*lfuItem).value = value
+	}
+	return ok
+}
+
+func (p *lfuPolicy) Add(key, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		e.Value.(*lfuItem).value = value
+		p.touch(e)
+		return
+	}
+	if len(p.items) >= p.size {
+		p.evict()
+	}
+	p.link(&lfuItem{key: key, value: value, freq: 1})
+	p.minFreq = 1
+}
+
+func (p *lfuPolicy) Remove(key interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		p.unlink(e)
+	}
+}
+
+func (p *lfuPolicy) Contains(key interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.items[key]
+	return ok
+}
+
+func (p *lfuPolicy) Keys() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	freqs := make([]int, 0, len(p.buckets))
+	for freq := range p.buckets {
+		freqs = append(freqs, freq)
+	}
+	sort.Ints(freqs)
+	keys := make([]interface{}, 0, len(p.items))
+	for _, freq := range freqs {
+		for e := p.buckets[freq].Back(); e != nil; e = e.Prev() {
+			keys = append(keys, e.Value.(*lfuItem).key)
+		}
+	}
+	return keys
+}
+
+func (p *lfuPolicy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.items)
+}
+
+func (p *lfuPolicy) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = map[interface{}]*list.Element{}
+	p.buckets = map[int]*list.List{}
+	p.minFreq = 0
+}
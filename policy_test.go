@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+var policies = map[string]PolicyFactory{
+	"lru":     NewLRUPolicy,
+	"arc":     NewARCPolicy,
+	"lfu":     NewLFUPolicy,
+	"tinylfu": NewTinyLFUPolicy,
+}
+
+func TestEvictionPolicy_Capacity(t *testing.T) {
+	for name, newPolicy := range policies {
+		evicted := map[interface{}]interface{}{}
+		p, err := newPolicy(10, func(key, value interface{}) { evicted[key] = value })
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		for i := 0; i < 100; i++ {
+			p.Add(fmt.Sprintf("key-%d", i), i)
+		}
+		if p.Len() > 10 {
+			t.Errorf("%s: expected at most 10 entries, got %d", name, p.Len())
+		}
+		if len(evicted)+p.Len() != 100 {
+			t.Errorf("%s: expected every entry to be kept or evicted, got %d evicted and %d kept", name, len(evicted), p.Len())
+		}
+		for _, key := range p.Keys() {
+			if _, ok := evicted[key]; ok {
+				t.Errorf("%s: %v was reported evicted but is still in the policy", name, key)
+			}
+			if v, ok := p.Peek(key); !ok || fmt.Sprintf("key-%d", v) != key {
+				t.Errorf("%s: expected the value for %v, got %v", name, key, v)
+			}
+		}
+		if len(p.Keys()) != p.Len() {
+			t.Errorf("%s: expected %d keys, got %d", name, p.Len(), len(p.Keys()))
+		}
+
+		// Remove and Purge aren't evictions
+		before := len(evicted)
+		p.Remove(p.Keys()[0])
+		p.Purge()
+		if len(evicted) != before || p.Len() != 0 {
+			t.Errorf("%s: expected Remove and Purge to not be reported as evictions", name)
+		}
+	}
+}
+
+func TestEvictionPolicy_Frequency(t *testing.T) {
+	// frequently used keys should survive a scan of one-off keys with the frequency aware policies
+	for _, name := range []string{"arc", "lfu", "tinylfu"} {
+		p, err := policies[name](100, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		for i := 0; i < 10; i++ {
+			p.Add(fmt.Sprintf("hot-%d", i), i)
+		}
+		for n := 0; n < 5; n++ {
+			for i := 0; i < 10; i++ {
+				p.Get(fmt.Sprintf("hot-%d", i))
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			p.Add(fmt.Sprintf("scan-%d", i), i)
+		}
+		for i := 0; i < 10; i++ {
+			if !p.Contains(fmt.Sprintf("hot-%d", i)) {
+				t.Errorf("%s: expected hot-%d to survive the scan", name, i)
+			}
+		}
+	}
+}
+
+func TestInMemoryStore_EvictionPolicies(t *testing.T) {
+	for name, newPolicy := range policies {
+		newCache := func(_ *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+			c, err := NewInMemoryStore(maxEntries, defaultExpiration, defCleanupInterval, false, "", WithEvictionPolicy(newPolicy))
+			if err != nil {
+				t.Fatalf("%s: can't create inmemory store: %s", name, err)
+			}
+			return c
+		}
+		inMemTypicalGetSet(t, newCache)
+		incrDecr(t, newCache)
+		emptyCache(t, newCache)
+		testAdd(t, newCache)
+		anyTypes(t, newCache)
+	}
+}
@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// tinyLFUWindowPercent - the percent of the entries in the admission window (the rest are in the main cache)
+	tinyLFUWindowPercent = 1
+	// tinyLFUProtectedPercent - the percent of the main cache for entries accessed more than once
+	tinyLFUProtectedPercent = 80
+	// tinyLFUSampleFactor - the sketch's counters are halved after size * tinyLFUSampleFactor accesses, so old
+	// popularity fades
+	tinyLFUSampleFactor = 10
+)
+
+// tinyLFUPolicy - W-TinyLFU: new entries go into a small LRU window, and when they're pushed out of the window
+// they're only admitted to the main cache (a segmented LRU) if they've been accessed more often than the entry the
+// main cache would evict for them.  Access frequencies come from a count-min sketch, so scans of one-off keys
+// can't flush out popular entries.
+type tinyLFUPolicy struct {
+	mu           sync.Mutex
+	windowCap    int
+	protectedCap int
+	mainCap      int
+	window       *list.List
+	probation    *list.List
+	protected    *list.List
+	items        map[interface{}]*list.Element
+	sketch       *countMinSketch
+	onEvict      EvictCallback
+}
+
+type tinyLFUItem struct {
+	key     interface{}
+	value   interface{}
+	hash    uint64
+	segment *list.List
+}
+
+// NewTinyLFUPolicy - creates a W-TinyLFU EvictionPolicy (scan resistant, with a high hit ratio for skewed workloads)
+func NewTinyLFUPolicy(size int, onEvict EvictCallback) (EvictionPolicy, error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	windowCap := size * tinyLFUWindowPercent / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := size - windowCap
+	return &tinyLFUPolicy{
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * tinyLFUProtectedPercent / 100,
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		items:        map[interface{}]*list.Element{},
+		sketch:       newCountMinSketch(size),
+		onEvict:      onEvict,
+	}, nil
+}
+
+func keyHash(key interface{}) uint64 {
+	h := fnv.New64a()
+	if s, ok := key.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, key)
+	}
+	return h.Sum64()
+}
+
+// access - record an access to the entry and move it for its segment
+func (p *tinyLFUPolicy) access(e *list.Element) {
+	item := e.Value.(*tinyLFUItem)
+	p.sketch.increment(item.hash)
+	switch item.segment {
+	case p.window, p.protected:
+		item.segment.MoveToFront(e)
+	case p.probation:
+		if p.protectedCap == 0 {
+			p.probation.MoveToFront(e)
+			return
+		}
+		// a second access in the main cache promotes it to protected, demoting protected's oldest if it's full
+		p.move(e, p.protected)
+		if p.protected.Len() > p.protectedCap {
+			p.move(p.protected.Back(), p.probation)
+		}
+	}
+}
+
+// move - move the entry to the front of the segment
+func (p *tinyLFUPolicy) move(e *list.Element, to *list.List) {
+	item := e.Value.(*tinyLFUItem)
+	item.segment.Remove(e)
+	item.segment = to
+	p.items[item.key] = to.PushFront(item)
+}
+
+func (p *tinyLFUPolicy) evict(e *list.Element) {
+	item := e.Value.(*tinyLFUItem)
+	item.segment.Remove(e)
+	delete(p.items, item.key)
+	if p.onEvict != nil {
+		p.onEvict(item.key, item.value)
+	}
+}
+
+func (p *tinyLFUPolicy) Get(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.items[key]
+	if !ok {
+		// misses count too, so an entry that's requested often is admitted once it's added
+		p.sketch.increment(keyHash(key))
+		return nil, false
+	}
+	value := e.Value.(*tinyLFUItem).value
+	p.access(e)
+	return value, true
+}
+
+func (p *tinyLFUPolicy) Peek(key interface{}) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		return e.Value.(*tinyLFUItem).value, true
+	}
+	return nil, false
+}
+
+func (p *tinyLFUPolicy) Update(key, value interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.items[key]
+	if ok {
+		e.Value.(*tinyLFUItem).value = value
+	}
+	return ok
+}
+
+func (p *tinyLFUPolicy) Add(key, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		e.Value.(*tinyLFUItem).value = value
+		p.access(e)
+		return
+	}
+	item := &tinyLFUItem{key: key, value: value, hash: keyHash(key), segment: p.window}
+	p.sketch.increment(item.hash)
+	p.items[key] = p.window.PushFront(item)
+	if p.window.Len() <= p.windowCap {
+		return
+	}
+
+	// the window's oldest entry is a candidate for the main cache
+	candidate := p.window.Back()
+	if p.probation.Len()+p.protected.Len() < p.mainCap {
+		p.move(candidate, p.probation)
+		return
+	}
+	victim := p.probation.Back()
+	if victim == nil {
+		victim = p.protected.Back()
+	}
+	if victim == nil {
+		p.evict(candidate)
+		return
+	}
+	if p.sketch.estimate(candidate.Value.(*tinyLFUItem).hash) > p.sketch.estimate(victim.Value.(*tinyLFUItem).hash) {
+		p.evict(victim)
+		p.move(candidate, p.probation)
+		return
+	}
+	p.evict(candidate)
+}
+
+func (p *tinyLFUPolicy) Remove(key interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.items[key]; ok {
+		e.Value.(*tinyLFUItem).segment.Remove(e)
+		delete(p.items, key)
+	}
+}
+
+func (p *tinyLFUPolicy) Contains(key interface{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.items[key]
+	return ok
+}
+
+// Keys - the keys in probation, then the window and then protected (each oldest first), which is roughly the
+// order they'd be evicted in
+func (p *tinyLFUPolicy) Keys() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]interface{}, 0, len(p.items))
+	for _, segment := range []*list.List{p.probation, p.window, p.protected} {
+		for e := segment.Back(); e != nil; e = e.Prev() {
+			keys = append(keys, e.Value.(*tinyLFUItem).key)
+		}
+	}
+	return keys
+}
+
+func (p *tinyLFUPolicy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.items)
+}
+
+func (p *tinyLFUPolicy) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.window.Init()
+	p.probation.Init()
+	p.protected.Init()
+	p.items = map[interface{}]*list.Element{}
+	p.sketch.clear()
+}
+
+// countMinSketch - approximate access counts in 4 rows of saturating 4 bit counters (stored in bytes)
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(size int) *countMinSketch {
+	width := 16
+	for width < size {
+		width <<= 1
+	}
+	s := &countMinSketch{mask: uint64(width - 1), resetAt: size * tinyLFUSampleFactor}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index - the counter for the hash in row i (double hashing)
+func (s *countMinSketch) index(hash uint64, i int) uint64 {
+	h1, h2 := hash, (hash>>32)|1
+	return (h1 + uint64(i)*h2) & s.mask
+}
+
+func (s *countMinSketch) increment(hash uint64) {
+	for i := range s.rows {
+		if idx := s.index(hash, i); s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(hash uint64) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(hash, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset - halve all the counters so old popularity fades
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) clear() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.additions = 0
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the roles of the pools made by this package, as they're labelled in their metrics
+const (
+	poolRoleSentinel = "sentinel"
+	poolRoleRedis    = "redis"
+	poolRoleReadOnly = "read_only"
+)
+
+// poolStats - what a pool's Stats() doesn't tell us, counted by the wrappers of its Dial and TestOnBorrow
+type poolStats struct {
+	dialFailures           uint64
+	testOnBorrowRejections uint64
+	// sentinel - the pool finds its master with sentinel lookups (see NewSentinelPool)
+	sentinel               bool
+	sentinelLookups        uint64
+	sentinelLookupFailures uint64
+}
+
+// instrumentedPools - the poolStats of each instrumented pool (until it's closed with ClosePool/ShutdownPool)
+var instrumentedPools = struct {
+	sync.Mutex
+	stats map[*redis.Pool]*poolStats
+}{
+	stats: map[*redis.Pool]*poolStats{},
+}
+
+// instrumentPool - wrap the pool's Dial and TestOnBorrow so their failures are counted in stats (or new poolStats
+// when stats is nil).  A pool is only instrumented once, and since the pool's funcs are replaced it has to be
+// instrumented before it's used.
+func instrumentPool(pool *redis.Pool, stats *poolStats) *poolStats {
+	instrumentedPools.Lock()
+	defer instrumentedPools.Unlock()
+	if existing, ok := instrumentedPools.stats[pool]; ok {
+		return existing
+	}
+	if stats == nil {
+		stats = &poolStats{}
+	}
+	if dial := pool.Dial; dial != nil {
+		pool.Dial = func() (redis.Conn, error) {
+			c, err := dial()
+			if err != nil {
+				atomic.AddUint64(&stats.dialFailures, 1)
+			}
+			return c, err
+		}
+	}
+	if testOnBorrow := pool.TestOnBorrow; testOnBorrow != nil {
+		pool.TestOnBorrow = func(c redis.Conn, t time.Time) error {
+			err := testOnBorrow(c, t)
+			if err != nil {
+				atomic.AddUint64(&stats.testOnBorrowRejections, 1)
+			}
+			return err
+		}
+	}
+	instrumentedPools.stats[pool] = stats
+	onPoolClose(pool, func() error {
+		instrumentedPools.Lock()
+		defer instrumentedPools.Unlock()
+		delete(instrumentedPools.stats, pool)
+		return nil
+	})
+	return stats
+}
+
+// sentinelLookup - count a lookup of the master (err is the lookup's error)
+func (s *poolStats) sentinelLookup(err error) {
+	atomic.AddUint64(&s.sentinelLookups, 1)
+	if err != nil {
+		atomic.AddUint64(&s.sentinelLookupFailures, 1)
+	}
+}
+
+// ExportPoolMetrics - export prometheus metrics for the pool, with a role label (e.g. "sentinel" or "read_only"),
+// so you can tell when it's starving for connections:
+//   - go_cache_redis_pool_active_connections, go_cache_redis_pool_idle_connections and
+//     go_cache_redis_pool_in_use_connections: from the pool's Stats()
+//   - go_cache_redis_pool_max_active_connections: the pool's MaxActive (0 is unlimited).  This version of redigo's
+//     Stats() doesn't count waits for a connection, so a pool that's starving shows as in use == max active.
+//   - go_cache_redis_pool_dial_failures_total and go_cache_redis_pool_test_on_borrow_rejections_total
+//   - go_cache_redis_pool_sentinel_lookups_total and go_cache_redis_pool_sentinel_lookup_failures_total: lookups of
+//     the master (only for NewSentinelPool's pools)
+//
+// The pool's Dial and TestOnBorrow are wrapped to count their failures, so it has to be called before the pool is
+// used.  Supported options: WithRegisterer, WithMetricNamespace and WithConstLabels.  The metrics are unregistered
+// when the pool is closed with ClosePool/ShutdownPool.  The pools made by this package can export their metrics with
+// WithPoolMetrics instead.
+func ExportPoolMetrics(pool *redis.Pool, role string, opt ...Option) error {
+	stats := instrumentPool(pool, nil)
+	r := newMetricsRegistry(GetOpts(opt...))
+	labels := prometheus.Labels{"role": role}
+	for k, v := range r.constLabels {
+		labels[k] = v
+	}
+	r.constLabels = labels
+
+	gauges := []struct {
+		name, help string
+		f          func() float64
+	}{
+		{"redis_pool_active_connections", "Number of connections in the redis pool (idle and in use)", func() float64 { return float64(pool.Stats().ActiveCount) }},
+		{"redis_pool_idle_connections", "Number of idle connections in the redis pool", func() float64 { return float64(pool.Stats().IdleCount) }},
+		{"redis_pool_in_use_connections", "Number of connections of the redis pool that are in use", func() float64 {
+			s := pool.Stats()
+			return float64(s.ActiveCount - s.IdleCount)
+		}},
+		{"redis_pool_max_active_connections", "Max number of connections in the redis pool (0 is unlimited)", func() float64 { return float64(pool.MaxActive) }},
+	}
+	counters := []struct {
+		name, help string
+		n          *uint64
+	}{
+		{"redis_pool_dial_failures_total", "Total number of failed dials of new connections for the redis pool", &stats.dialFailures},
+		{"redis_pool_test_on_borrow_rejections_total", "Total number of idle connections of the redis pool rejected by TestOnBorrow", &stats.testOnBorrowRejections},
+	}
+	if stats.sentinel {
+		counters = append(counters, []struct {
+			name, help string
+			n          *uint64
+		}{
+			{"redis_pool_sentinel_lookups_total", "Total number of lookups of the redis master from the sentinels", &stats.sentinelLookups},
+			{"redis_pool_sentinel_lookup_failures_total", "Total number of failed lookups of the redis master from the sentinels", &stats.sentinelLookupFailures},
+		}...)
+	}
+	for _, g := range gauges {
+		if err := r.gaugeFunc(g.f, g.name, g.help); err != nil {
+			r.unregister()
+			return err
+		}
+	}
+	for _, c := range counters {
+		n := c.n
+		if err := r.counterFunc(func() float64 { return float64(atomic.LoadUint64(n)) }, c.name, c.help); err != nil {
+			r.unregister()
+			return err
+		}
+	}
+	onPoolClose(pool, func() error {
+		r.unregister()
+		return nil
+	})
+	return nil
+}
+
+// exportPoolMetrics - ExportPoolMetrics for the pools made by this package, when the options ask for it (see
+// WithPoolMetrics)
+func exportPoolMetrics(pool *redis.Pool, role string, opt ...Option) error {
+	if !GetOpts(opt...)[optionWithPoolMetrics].(bool) {
+		return nil
+	}
+	return ExportPoolMetrics(pool, role, opt...)
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// gatheredValues - the value of each (unlabelled, apart from the const labels) metric in the registry
+func gatheredValues(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		m := f.GetMetric()[0]
+		switch f.GetType() {
+		case dto.MetricType_GAUGE:
+			got[f.GetName()] = m.GetGauge().GetValue()
+		case dto.MetricType_COUNTER:
+			got[f.GetName()] = m.GetCounter().GetValue()
+		}
+	}
+	return got
+}
+
+func TestExportPoolMetrics(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	addr := m.Addr()
+	reject, failDial := false, false
+	pool := &redis.Pool{
+		MaxIdle:   4,
+		MaxActive: 4,
+		Dial: func() (redis.Conn, error) {
+			if failDial {
+				return nil, errors.New("failed")
+			}
+			return redis.Dial("tcp", addr)
+		},
+		TestOnBorrow: func(c redis.Conn, _ time.Time) error {
+			if reject {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	}
+	reg := prometheus.NewRegistry()
+	if err := ExportPoolMetrics(pool, "test", WithRegisterer(reg)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ExportPoolMetrics(pool, "test", WithRegisterer(reg)); err == nil {
+		t.Errorf("expected exporting the same role twice on a registerer to be an error")
+	}
+
+	inUse := pool.Get()
+	inUse.Do("PING")
+	idle := pool.Get()
+	idle.Do("PING")
+	idle.Close()
+	reject = true
+	pool.Get().Close() // rejects the idle conn and dials a new one
+	failDial = true
+	pool.Get().Close() // rejects the idle conn, and the dial fails
+
+	got := gatheredValues(t, reg)
+	expected := map[string]float64{
+		"go_cache_redis_pool_active_connections":              1,
+		"go_cache_redis_pool_idle_connections":                0,
+		"go_cache_redis_pool_in_use_connections":              1,
+		"go_cache_redis_pool_max_active_connections":          4,
+		"go_cache_redis_pool_dial_failures_total":             1,
+		"go_cache_redis_pool_test_on_borrow_rejections_total": 2,
+	}
+	for name, want := range expected {
+		if got[name] != want {
+			t.Errorf("expected %s to be %v, got %v", name, want, got[name])
+		}
+	}
+	inUse.Close()
+	ClosePool(pool)
+	if got := gatheredValues(t, reg); len(got) != 0 {
+		t.Errorf("expected the metrics to be unregistered when the pool is closed, got %v", got)
+	}
+}
+
+func TestNewSentinelPool_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pool := NewSentinelPool([]string{"127.0.0.1:1"}, nil, nil, 50, 50, 0, nil, WithPoolMetrics(true), WithRegisterer(reg))
+	defer ClosePool(pool)
+	if err := pool.Get().Err(); err == nil {
+		t.Fatalf("expected the master lookup to fail")
+	}
+	got := gatheredValues(t, reg)
+	if got["go_cache_redis_pool_sentinel_lookups_total"] != 1 || got["go_cache_redis_pool_sentinel_lookup_failures_total"] != 1 ||
+		got["go_cache_redis_pool_dial_failures_total"] != 1 {
+		t.Errorf("expected a failed lookup and dial, got %v", got)
+	}
+
+	// a clash is logged to the logger that was passed in
+	var logged bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logged
+	clash := NewSentinelPool([]string{"127.0.0.1:1"}, nil, nil, 50, 50, 0, logrus.NewEntry(logger), WithPoolMetrics(true), WithRegisterer(reg))
+	defer ClosePool(clash)
+	if !strings.Contains(logged.String(), "can't export the pool's metrics") {
+		t.Errorf("expected the clash to be logged, got %q", logged.String())
+	}
+}
@@ -40,7 +40,8 @@ const (
 	defReadWriteTimeoutMilliseconds  = 50
 )
 
-// NewSentinelPool - create a new pool for Redis Sentinel
+// NewSentinelPool - create a new pool for Redis Sentinel.  If WithPoolMetrics is set and the pool's metrics can't be
+// exported, the error is logged to logger (if it isn't nil) and the pool is returned without them.
 func NewSentinelPool(
 	sentinelAddrs []string,
 	masterIdentifier []byte,
@@ -48,7 +49,25 @@ func NewSentinelPool(
 	connectionTimeoutMilliseconds int,
 	readWriteTimeoutMilliseconds int,
 	selectDatabase int,
-	logger *logrus.Entry) *redis.Pool {
+	logger *logrus.Entry,
+	opt ...Option) *redis.Pool {
+	pool, err := newSentinelPool(sentinelAddrs, masterIdentifier, redisPassword, connectionTimeoutMilliseconds, readWriteTimeoutMilliseconds, selectDatabase, opt...)
+	if err != nil && logger != nil {
+		logger.Errorf("NewSentinelPool: can't export the pool's metrics: %s", err.Error())
+	}
+	return pool
+}
+
+// newSentinelPool - NewSentinelPool, returning the error if the pool's metrics can't be exported (the pool is still
+// returned, so it can be closed)
+func newSentinelPool(
+	sentinelAddrs []string,
+	masterIdentifier []byte,
+	redisPassword []byte,
+	connectionTimeoutMilliseconds int,
+	readWriteTimeoutMilliseconds int,
+	selectDatabase int,
+	opt ...Option) (*redis.Pool, error) {
 	if masterIdentifier == nil {
 		masterIdentifier = []byte("mymaster")
 	}
@@ -66,13 +85,15 @@ func NewSentinelPool(
 		},
 	}
 
-	return &redis.Pool{
+	stats := &poolStats{sentinel: true}
+	pool := &redis.Pool{
 		MaxIdle:     3,
 		MaxActive:   64,
 		Wait:        true,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
 			redisHostAddr, errHostAddr := sntnl.MasterAddr()
+			stats.sentinelLookup(errHostAddr)
 			if errHostAddr != nil {
 				return nil, errHostAddr
 			}
@@ -108,6 +129,50 @@ func NewSentinelPool(
 			return nil
 		},
 	}
+	// the sentinel has its own connections, which are closed with the pool (see ClosePool)
+	onPoolClose(pool, sntnl.Close)
+	instrumentPool(pool, stats)
+	return pool, exportPoolMetrics(pool, poolRoleSentinel, opt...)
+}
+
+// newRedisPool - the pool persistence.NewRedisCache makes, but one we can close
+func newRedisPool(host string, password string, selectDatabase int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     5,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial(network, host)
+			if err != nil {
+				return nil, err
+			}
+			if len(password) > 0 {
+				if _, err := c.Do("AUTH", password); err != nil {
+					c.Close()
+					return nil, err
+				}
+			} else {
+				// check with PING
+				if _, err := c.Do("PING"); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			if selectDatabase != 0 {
+				if _, err := c.Do("SELECT", selectDatabase); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, err
+		},
+		// custom connection test method
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if _, err := c.Do("PING"); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
 }
 
 // inCluster - are we executing in the K8s cluster
@@ -142,7 +207,7 @@ type RedisConnectionInfo struct {
 }
 
 // New - Make a new cache interface using a RedisConnectionInfo for setup - it doesn't not rely on the ENV at all
-func (connInfo *RedisConnectionInfo) New(testWriteRead bool, logger *logrus.Entry) (interface{}, error) {
+func (connInfo *RedisConnectionInfo) New(testWriteRead bool, logger *logrus.Entry, opt ...Option) (interface{}, error) {
 	if len(connInfo.MasterIdentifier) == 0 {
 		logger.Info("RedisConnectionInfo.New: master identifier is not defined, using mymaster")
 		connInfo.MasterIdentifier = "mymaster"
@@ -206,12 +271,19 @@ func (connInfo *RedisConnectionInfo) New(testWriteRead bool, logger *logrus.Entr
 			storeDNS = "localhost:6379"
 		}
 		logger.Infof("RedisConnectionInfo.New: using cache at: %s", storeDNS)
-		cache = persistence.NewRedisCache(storeDNS, connInfo.Password, storeExp) // if password is 0 len, then no AUTH is used for redis
+		pool := newRedisPool(storeDNS, connInfo.Password, 0) // if password is 0 len, then no AUTH is used for redis
+		if err := exportPoolMetrics(pool, poolRoleRedis, opt...); err != nil {
+			logger.Errorf("RedisConnectionInfo.New: can't export the pool's metrics: %s", err.Error())
+			ClosePool(pool)
+			return nil, err
+		}
+		cache = NewRedisStore(pool, storeExp)
 		if testWriteRead {
 			var v string
 			var err error
 			if v, err = testCache(cache, "testing", "1,2,3..", storeExp); err != nil {
 				logger.Errorf("RedisConnectionInfo.New: cache test failed: %s", err.Error())
+				closeUnreturnedStore(cache)
 				return nil, err
 			}
 			logger.Infof("RedisConnectionInfo.New: cache test success: %s", v)
@@ -219,18 +291,24 @@ func (connInfo *RedisConnectionInfo) New(testWriteRead bool, logger *logrus.Entr
 		return cache, nil
 	}
 	addrs := []string{sentinelHost}
-	sntlPool := NewSentinelPool(
+	sntlPool, err := newSentinelPool(
 		addrs,
 		[]byte(connInfo.MasterIdentifier),
 		redisPassword,
 		connInfo.ConnectionTimeoutMilliseconds,
 		connInfo.ReadWriteTimeoutMilliseconds,
 		connInfo.SelectDatabase,
-		logger)
-	cache = persistence.NewRedisCacheWithPool(sntlPool, storeExp)
+		opt...)
+	if err != nil {
+		logger.Errorf("RedisConnectionInfo.New: can't export the pool's metrics: %s", err.Error())
+		ClosePool(sntlPool)
+		return nil, err
+	}
+	cache = NewRedisStore(sntlPool, storeExp)
 	if testWriteRead {
 		if err := cache.(persistence.CacheStore).Set("test", "this", storeExp); err != nil {
 			logger.Errorf("RedisConnectionInfo.New: cache test failed: %s", err.Error())
+			closeUnreturnedStore(cache)
 			return nil, err
 		}
 		var v string
@@ -254,7 +332,8 @@ func InitRedisCache(
 	connectionTimeoutMilliseconds int,
 	readWriteTimeoutMilliseconds int,
 	selectDatabase int,
-	logger *logrus.Entry) (interface{}, error) {
+	logger *logrus.Entry,
+	opt ...Option) (interface{}, error) {
 	masterIdentifier := os.Getenv("REDIS_MASTER_IDENTIFIER")
 	if len(masterIdentifier) == 0 {
 		logger.Info("Env REDIS_MASTER_IDENTIFIER is not defined, using mymaster")
@@ -301,25 +380,37 @@ func InitRedisCache(
 			storeDNS = "localhost:6379"
 		}
 		logger.Infof("InitCache: using cache at: %s", storeDNS)
-		cache = persistence.NewRedisCache(storeDNS, string(redisPassword), storeExp, persistence.WithSelectDatabase(selectDatabase))
+		pool := newRedisPool(storeDNS, string(redisPassword), selectDatabase)
+		if err := exportPoolMetrics(pool, poolRoleRedis, opt...); err != nil {
+			logger.Errorf("initCache: can't export the pool's metrics: %s", err.Error())
+			ClosePool(pool)
+			return nil, err
+		}
+		cache = NewRedisStore(pool, storeExp)
 		var v string
 		var err error
 		if v, err = testCache(cache, "testing", "1,2,3..", storeExp); err != nil {
 			logger.Errorf("initCache: cache test failed: %s", err.Error())
+			closeUnreturnedStore(cache)
 			return nil, err
 		}
 		logger.Infof("initCache: cache test success: %s", v)
 		return cache, nil
 	}
 	addrs := []string{redisHost}
-	sntlPool := NewSentinelPool(addrs, []byte(masterIdentifier), redisPassword, connectionTimeoutMilliseconds, readWriteTimeoutMilliseconds, selectDatabase, logger)
-	cache = persistence.NewRedisCacheWithPool(sntlPool, storeExp)
+	sntlPool, err := newSentinelPool(addrs, []byte(masterIdentifier), redisPassword, connectionTimeoutMilliseconds, readWriteTimeoutMilliseconds, selectDatabase, opt...)
+	if err != nil {
+		logger.Errorf("initCache: can't export the pool's metrics: %s", err.Error())
+		ClosePool(sntlPool)
+		return nil, err
+	}
+	cache = NewRedisStore(sntlPool, storeExp)
 	if err := cache.(persistence.CacheStore).Set("test", "this", storeExp); err != nil {
 		logger.Errorf("initCache: cache test failed: %s", err.Error())
+		closeUnreturnedStore(cache)
 		return nil, err
 	}
 	var v string
-	var err error
 	if v, err = testCache(cache, "testing", "1,2,3..", storeExp); err != nil {
 		logger.Errorf("initCache: cache test failed: %s", err.Error())
 		return cache, err
@@ -350,7 +441,8 @@ func InitReadOnlyRedisCache(
 	defaultExpMinutes int,
 	maxConnections int,
 	selectDatabase int,
-	logger *logrus.Entry) (interface{}, error) {
+	logger *logrus.Entry,
+	opt ...Option) (interface{}, error) {
 	var cache interface{}
 	logger.Debugf("InitReadOnlyRedisCache: trying to init read-only redis cache")
 	defExpSeconds := int(60 * defaultExpMinutes)
@@ -425,5 +517,10 @@ func InitReadOnlyRedisCache(
 		// 	return nil
 		// },
 	}
-	return persistence.NewRedisCacheWithPool(pool, time.Duration(defExpSeconds)*time.Second), nil
+	if err := exportPoolMetrics(pool, poolRoleReadOnly, opt...); err != nil {
+		logger.Errorf("InitReadOnlyRedisCache: can't export the pool's metrics: %s", err.Error())
+		ClosePool(pool)
+		return cache, err
+	}
+	return NewRedisStore(pool, time.Duration(defExpSeconds)*time.Second), nil
 }
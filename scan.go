@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// defaultScanBatchSize - the batch size when Scan is given one <= 0
+const defaultScanBatchSize = 100
+
+// EntryInfo - metadata about an entry, from Inspect
+//   - TimeAdded: epoc at the time of addition (0 when the store doesn't know it, e.g. a Redis value that isn't a
+//     GenericCacheEntry)
+//   - ExpiresAt: epoc at the time of expiry (0 when it never expires)
+//   - Encrypted: the entry is encrypted
+//   - Signed: the entry is signed (see WithSignData)
+//   - Size: the size in bytes of the stored value (for in memory entries that aren't sealed it's the size of the
+//     serialized value)
+type EntryInfo struct {
+	Key       string
+	TimeAdded int64
+	ExpiresAt int64
+	Encrypted bool
+	Signed    bool
+	Size      int
+}
+
+// KeyIterator - iterates over keys a batch at a time.  Keys added or removed during the iteration may or may not be
+// returned, and a key may be returned more than once (as with Redis SCAN).
+//
+//	it := c.Scan("user:", 100)
+//	for it.Next() {
+//		info, err := it.Info()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type KeyIterator struct {
+	fetch   func() (keys []string, done bool, err error)
+	inspect func(key string) (EntryInfo, error)
+	batch   []string
+	key     string
+	done    bool
+	err     error
+}
+
+// Next - advance to the next key, returning false when there are no more keys (or there's an error)
+func (it *KeyIterator) Next() bool {
+	for len(it.batch) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.batch, it.done, it.err = it.fetch()
+		if it.err != nil {
+			return false
+		}
+	}
+	it.key, it.batch = it.batch[0], it.batch[1:]
+	return true
+}
+
+// Key - the current key
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+// Info - Inspect the current key
+func (it *KeyIterator) Info() (EntryInfo, error) {
+	return it.inspect(it.key)
+}
+
+// Err - the error that stopped the iteration (nil when it ran out of keys)
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// sealedInfo - fill in the info from the entry's value, which may be a sealed entry
+func sealedInfo(info *EntryInfo, data interface{}) {
+	if b, ok := data.([]byte); ok {
+		info.Size = len(b)
+		if isSealedEntry(b) {
+			flags := sealedFlags(b)
+			info.Encrypted = flags&entryFlagEncrypt != 0
+			info.Signed = flags&entryFlagSigned != 0
+		}
+		return
+	}
+	if b, err := utils.Serialize(data); err == nil {
+		info.Size = len(b)
+	}
+}
+
+// scanKeys - a KeyIterator over the unexpired keys of the in memory stores that start with prefix.  Each store's
+// keys are copied from its eviction policy (which can only list all of them) when the iteration reaches it, and
+// they're checked without promoting them in the eviction policy.
+func scanKeys(prefix string, batchSize int, inspect func(string) (EntryInfo, error), stores ...*inMemoryStore) *KeyIterator {
+	if batchSize <= 0 {
+		batchSize = defaultScanBatchSize
+	}
+	var keys []interface{}
+	next := 0
+	return &KeyIterator{
+		inspect: inspect,
+		fetch: func() ([]string, bool, error) {
+			batch := make([]string, 0, batchSize)
+			for len(batch) < batchSize {
+				if len(keys) == 0 {
+					if next == len(stores) {
+						return batch, true, nil
+					}
+					keys = stores[next].policy.Keys()
+					next++
+					continue
+				}
+				key := keys[0].(string)
+				keys = keys[1:]
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				if v, ok := stores[next-1].policy.Peek(key); ok {
+					if entry := v.(GenericCacheEntry); !entry.Expired() {
+						batch = append(batch, key)
+					}
+				}
+			}
+			return batch, false, nil
+		},
+	}
+}
+
+// inspect - the EntryInfo for key, without promoting it in the eviction policy
+func (c *inMemoryStore) inspect(key string) (EntryInfo, error) {
+	v, ok := c.policy.Peek(key)
+	if !ok {
+		return EntryInfo{}, persistence.ErrCacheMiss
+	}
+	entry := v.(GenericCacheEntry)
+	if entry.Expired() {
+		return EntryInfo{}, persistence.ErrCacheMiss
+	}
+	info := EntryInfo{Key: key, TimeAdded: entry.TimeAdded, ExpiresAt: entry.ExpiresAt}
+	sealedInfo(&info, entry.Data)
+	return info, nil
+}
+
+// Scan - iterate over the keys that start with prefix, batchSize keys at a time (see KeyIterator), skipping expired
+// entries.  Like Keys, it copies all the store's keys (when Next is first called), since the eviction policies can't
+// be walked a batch at a time; a ShardedInMemoryStore copies one shard's keys at a time.
+func (c *InMemoryStore) Scan(prefix string, batchSize int) *KeyIterator {
+	return scanKeys(prefix, batchSize, c.Inspect, c.inMemoryStore)
+}
+
+// Inspect - the metadata of the entry for key (without promoting it in the eviction policy)
+func (c *InMemoryStore) Inspect(key string) (EntryInfo, error) {
+	return c.inspect(key)
+}
+
+// Scan - iterate over the keys of all the shards that start with prefix, copying one shard's keys at a time (see
+// InMemoryStore.Scan)
+func (c *ShardedInMemoryStore) Scan(prefix string, batchSize int) *KeyIterator {
+	stores := make([]*inMemoryStore, len(c.shards))
+	for i, s := range c.shards {
+		stores[i] = s.inMemoryStore
+	}
+	return scanKeys(prefix, batchSize, c.Inspect, stores...)
+}
+
+// Inspect - the metadata of the entry for key (see InMemoryStore.Inspect)
+func (c *ShardedInMemoryStore) Inspect(key string) (EntryInfo, error) {
+	return c.shard(key).inspect(key)
+}
+
+// globEscaper - escapes the glob characters of a Redis MATCH pattern
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// scanRedis - a KeyIterator using SCAN MATCH
+func scanRedis(pool *redis.Pool, prefix string, batchSize int, inspect func(string) (EntryInfo, error)) *KeyIterator {
+	if batchSize <= 0 {
+		batchSize = defaultScanBatchSize
+	}
+	match := globEscaper.Replace(prefix) + "*"
+	cursor := "0"
+	return &KeyIterator{
+		inspect: inspect,
+		fetch: func() ([]string, bool, error) {
+			conn := pool.Get()
+			defer conn.Close()
+			reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", batchSize))
+			if err != nil {
+				return nil, true, err
+			}
+			var keys []string
+			if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+				return nil, true, err
+			}
+			return keys, cursor == "0", nil
+		},
+	}
+}
+
+// inspectRedis - the EntryInfo for key from its TTL and value.  Redis doesn't know when the entry was added, so
+// TimeAdded is read from the value when it's a GenericCacheEntry.
+func (c *GenericCache) inspectRedis(pool *redis.Pool, key string) (EntryInfo, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	conn.Send("PTTL", key)
+	conn.Send("GET", key)
+	if err := conn.Flush(); err != nil {
+		return EntryInfo{}, err
+	}
+	ttl, err := redis.Int64(conn.Receive())
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	value, err := redis.Bytes(conn.Receive())
+	if err == redis.ErrNil || ttl == -2 {
+		return EntryInfo{}, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	info := EntryInfo{Key: key, TimeAdded: c.timeAdded(key, value)}
+	if ttl >= 0 {
+		info.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond).Unix()
+	}
+	sealedInfo(&info, value)
+	return info, nil
+}
+
+// timeAdded - the TimeAdded of a stored GenericCacheEntry (0 for other values and entries that can't be unsealed).
+// Only TimeAdded is decoded, so the type of the entry's Data doesn't have to be registered with gob.
+func (c *GenericCache) timeAdded(key string, stored []byte) int64 {
+	data := stored
+	if isSealedEntry(stored) {
+		var err error
+		if data, err = c.unsealEntry(key, stored); err != nil {
+			return 0
+		}
+	}
+	var entry struct{ TimeAdded int64 }
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return 0
+	}
+	return entry.TimeAdded
+}
+
+// keyScanner - a store that can be scanned and inspected
+type keyScanner interface {
+	Scan(prefix string, batchSize int) *KeyIterator
+	Inspect(key string) (EntryInfo, error)
+}
+
+// Scan - iterate over the cache's keys that start with prefix, batchSize keys at a time, without promoting them in
+// an in memory store's eviction policy (Redis stores use SCAN MATCH, so batchSize is a hint).  Stores that can't be
+// scanned (e.g. a RedisStore without a pool) return an iterator with ErrNotSupport.
+func (c *GenericCache) Scan(prefix string, batchSize int) *KeyIterator {
+	switch store := c.Cache.(type) {
+	case keyScanner:
+		return store.Scan(prefix, batchSize)
+	case *persistence.RedisStore:
+		if pool := redisPoolOf(store); pool != nil {
+			return scanRedis(pool, prefix, batchSize, c.Inspect)
+		}
+	}
+	c.logError(fmt.Sprintf("GenericCache.Scan: L%v/T%v error - scanning is not supported by %T", c.cLevel, c.cType, c.Cache))
+	return &KeyIterator{err: persistence.ErrNotSupport, inspect: c.Inspect}
+}
+
+// Inspect - the metadata of the entry for key: when it was added and expires, whether it's encrypted or signed and
+// its size.  Returns ErrCacheMiss when the key isn't in the cache.
+func (c *GenericCache) Inspect(key string) (EntryInfo, error) {
+	switch store := c.Cache.(type) {
+	case keyScanner:
+		return store.Inspect(key)
+	case *persistence.RedisStore:
+		if pool := redisPoolOf(store); pool != nil {
+			return c.inspectRedis(pool, key)
+		}
+	}
+	return EntryInfo{}, persistence.ErrNotSupport
+}
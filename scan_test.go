@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestGenericCache_Scan(t *testing.T) {
+	r, err := initTestRedis(t)
+	if err != nil {
+		t.Fatal("Unable to init test redis: ", err)
+	}
+	defer r.Close()
+
+	scanAndInspect(t, newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache), false)
+	scanAndInspect(t, newExpiryLRUInMemoryStoreEncrypted(t, time.Hour).(*GenericCache), true)
+	scanAndInspect(t, newGenericStoreRedisEncrypted(t, time.Hour).(*GenericCache), true)
+
+	sharded, err := NewShardedInMemoryStore(4, maxEntries, time.Hour, 0, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	scanAndInspect(t, NewCacheWithPool(sharded, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false), false)
+
+	unsupported := NewCacheWithPool(persistence.NewRedisCacheWithPool(nil, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), false)
+	if it := unsupported.Scan("", 10); it.Next() || it.Err() != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", it.Err())
+	}
+}
+
+func scanAndInspect(t *testing.T, c *GenericCache, encrypted bool) {
+	for i := 0; i < 25; i++ {
+		if err := c.Set(fmt.Sprintf("scan:%02d", i), "value", time.Minute); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+	}
+	c.Set("other", "value", time.Minute)
+
+	var keys []string
+	it := c.Scan("scan:", 10)
+	for it.Next() {
+		keys = append(keys, it.Key())
+		info, err := it.Info()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if info.Key != it.Key() || info.Encrypted != encrypted || info.Size == 0 {
+			t.Errorf("unexpected info %+v", info)
+		}
+		if remaining := time.Until(time.Unix(info.ExpiresAt, 0)); remaining <= 0 || remaining > time.Minute+time.Second {
+			t.Errorf("expected %s to expire in about a minute, got %s", info.Key, remaining)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sort.Strings(keys)
+	// Redis SCAN may return a key more than once
+	unique := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			unique = append(unique, k)
+		}
+	}
+	if len(unique) != 25 || unique[0] != "scan:00" || unique[24] != "scan:24" {
+		t.Errorf("expected the 25 scan: keys, got %v", unique)
+	}
+
+	if _, err := c.Inspect("missing"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	for i := 0; i < 25; i++ {
+		c.Delete(fmt.Sprintf("scan:%02d", i))
+	}
+	c.Delete("other")
+}
+
+func TestGenericCache_ScanRedisStore(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	defer pool.Close()
+	for _, encrypted := range []bool{false, true} {
+		// a store that wasn't made by this package
+		c := NewCacheWithPool(persistence.NewRedisCacheWithPool(pool, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), encrypted)
+		scanAndInspect(t, c, encrypted)
+
+		entry := c.NewGenericCacheEntry("entry", time.Minute)
+		entry.TimeAdded -= 100
+		if err := c.Set("entry", entry, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		info, err := c.Inspect("entry")
+		if err != nil || info.TimeAdded != entry.TimeAdded {
+			t.Errorf("encrypted == %v: expected the entry's TimeAdded %d, got %+v - %v", encrypted, entry.TimeAdded, info, err)
+		}
+		if err := c.Set("string", "value", time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if info, err := c.Inspect("string"); err != nil || info.TimeAdded != 0 {
+			t.Errorf("encrypted == %v: expected no TimeAdded for a string, got %+v - %v", encrypted, info, err)
+		}
+	}
+}
+
+func TestInMemoryStore_ScanDoesNotPromote(t *testing.T) {
+	c, err := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithEvictionPolicy(NewLRUPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), i, time.Hour)
+	}
+	c.put("expired", GenericCacheEntry{Data: 1, ExpiresAt: time.Now().Unix() - 10})
+	before := c.Keys()
+	n := 0
+	for it := c.Scan("key-", 2); it.Next(); n++ {
+		if _, err := it.Info(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if n != 5 {
+		t.Errorf("expected 5 keys, got %d", n)
+	}
+	after := c.Keys()
+	for i := range before {
+		if before[i] != after[i] {
+			t.Errorf("expected Scan to keep the recency order %v, got %v", before, after)
+			break
+		}
+	}
+	if _, err := c.Inspect("expired"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected an expired entry to be a miss, got %v", err)
+	}
+}
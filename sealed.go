@@ -0,0 +1,522 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// sealed entries start with a header: entryMagic, entryVersion and a flags byte saying how the payload was sealed.
+// The payload is the gob encoded value (encrypted when entryFlagEncrypt) followed by an HMAC when entryFlagSigned.
+// Sliding entries (entryFlagSliding) have their slidingExpiry between the header and the payload.
+var entryMagic = []byte{0x00, 'g', 'c'}
+
+const (
+	entryVersion     byte = 1
+	entryHeaderLen        = 5 // magic + version + flags
+	entryFlagEncrypt byte = 1 << 0
+	entryFlagSigned  byte = 1 << 1
+	entryFlagSliding byte = 1 << 2
+)
+
+// EntryOption - how per entry options are passed to SetWithOptions, AddWithOptions and ReplaceWithOptions
+type EntryOption func(*entryOptions)
+
+type entryOptions struct {
+	encrypt     *bool
+	exactExpiry bool
+	sliding     *bool
+	maxLifetime *time.Duration
+}
+
+func getEntryOpts(opt ...EntryOption) entryOptions {
+	opts := entryOptions{}
+	for _, o := range opt {
+		o(&opts)
+	}
+	return opts
+}
+
+// WithEntryEncryption - force (true) or skip (false) encryption of this entry, overriding the cache's EncryptData
+func WithEntryEncryption(encrypt bool) EntryOption {
+	return func(o *entryOptions) {
+		o.encrypt = &encrypt
+	}
+}
+
+// isSealedEntry - does the stored data start with a sealed entry header?
+func isSealedEntry(data []byte) bool {
+	return len(data) >= entryHeaderLen && bytes.Equal(data[:len(entryMagic)], entryMagic)
+}
+
+// sealedFlags - the header flags of a sealed entry
+func sealedFlags(sealed []byte) byte {
+	return sealed[len(entryMagic)+1]
+}
+
+// signEntry - append an HMAC of the key and the data.  The key is included so a signed entry can't be copied to
+// another key.
+func signEntry(key string, data []byte, secret []byte) []byte {
+	signed := make([]byte, 0, len(data)+sha256.Size)
+	signed = append(signed, data...)
+	return append(signed, entryMAC(append([]byte(key+"\x00"), data...), secret)...)
+}
+
+// verifyEntry - check the HMAC appended by signEntry and return the data without it
+func verifyEntry(key string, signed []byte, secret []byte) ([]byte, error) {
+	if len(signed) < sha256.Size {
+		return nil, ErrIntegrityCheckFailed
+	}
+	data, sig := signed[:len(signed)-sha256.Size], signed[len(signed)-sha256.Size:]
+	if !hmac.Equal(sig, entryMAC(append([]byte(key+"\x00"), data...), secret)) {
+		return nil, ErrIntegrityCheckFailed
+	}
+	return data, nil
+}
+
+// sealsEntries - does the cache seal all of its entries (see sealEntry)
+func (c *GenericCache) sealsEntries() bool {
+	return c.EncryptData || c.SignData
+}
+
+// sealEntry - gob encodes data (which keeps its type information), encrypts it if encrypt and signs it if
+// SignData, so any value type can be stored encrypted and/or signed.  The result is always a []byte which every
+// store can hold.  A non-nil slide makes it a sliding entry.
+func (c *GenericCache) sealEntry(key string, data interface{}, encrypt bool, slide *slidingExpiry) ([]byte, error) {
+	var b bytes.Buffer
+	endSerialize := c.stage(stageSerialize)
+	err := gob.NewEncoder(&b).Encode(data)
+	endSerialize()
+	if err != nil {
+		err = fmt.Errorf("GenericCache.sealEntry: can't encode %T: %s", data, err.Error())
+		return nil, err
+	}
+	payload := b.Bytes()
+	var flags byte
+	if encrypt {
+		var err error
+		if payload, err = c.encryptEntry(payload); err != nil {
+			return nil, err
+		}
+		flags |= entryFlagEncrypt
+	}
+	if c.SignData {
+		flags |= entryFlagSigned
+	}
+	if slide != nil {
+		flags |= entryFlagSliding
+	}
+	sealed := make([]byte, 0, entryHeaderLen+slidingExpiryLen+len(payload))
+	sealed = append(sealed, entryMagic...)
+	sealed = append(sealed, entryVersion, flags)
+	if slide != nil {
+		sealed = slide.appendTo(sealed)
+	}
+	sealed = append(sealed, payload...)
+	if c.SignData {
+		sealed = signEntry(key, sealed, c.sharedSecret)
+	}
+	c.tagPayloadSize(len(sealed))
+	return sealed, nil
+}
+
+// unsealEntry - verifies and decrypts an entry written by sealEntry, returning the gob encoded data
+func (c *GenericCache) unsealEntry(key string, sealed []byte) ([]byte, error) {
+	if !isSealedEntry(sealed) {
+		if c.SignData {
+			return nil, ErrIntegrityCheckFailed
+		}
+		return nil, fmt.Errorf("GenericCache.unsealEntry: key %s is not a sealed entry", key)
+	}
+	flags := sealedFlags(sealed)
+	if flags&entryFlagSigned != 0 {
+		var err error
+		if sealed, err = verifyEntry(key, sealed, c.sharedSecret); err != nil {
+			return nil, err
+		}
+	} else if c.SignData {
+		// an unsigned entry in a cache that signs its entries wasn't written by us
+		return nil, ErrIntegrityCheckFailed
+	}
+	if sealed[len(entryMagic)] != entryVersion {
+		return nil, fmt.Errorf("GenericCache.unsealEntry: key %s has an unsupported entry version %d", key, sealed[len(entryMagic)])
+	}
+	payload := sealed[entryHeaderLen:]
+	if flags&entryFlagSliding != 0 {
+		if len(payload) < slidingExpiryLen {
+			return nil, fmt.Errorf("GenericCache.unsealEntry: key %s has a truncated sliding expiry", key)
+		}
+		payload = payload[slidingExpiryLen:]
+	}
+	if flags&entryFlagEncrypt != 0 {
+		return c.decryptEntry(payload)
+	}
+	return payload, nil
+}
+
+// openEntry - unseals an entry written by sealEntry and decodes it into value (which must be a pointer)
+func (c *GenericCache) openEntry(key string, sealed []byte, value interface{}) error {
+	data, err := c.unsealEntry(key, sealed)
+	if err != nil {
+		return err
+	}
+	defer c.stage(stageDeserialize)()
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(value); err != nil {
+		err = fmt.Errorf("GenericCache.openEntry: can't decode into %T: %s", value, err.Error())
+		return err
+	}
+	return nil
+}
+
+// storeValue - returns what should be handed to the store for data.  Entries are sealed when the cache encrypts or
+// signs its entries, when the entry's options force encryption, or when it's a sliding entry (slide isn't nil).
+// Other entries for stores of bytes are serialized here (the way the store would), so the serialization is timed.
+func (c *GenericCache) storeValue(key string, data interface{}, opts entryOptions, slide *slidingExpiry) (interface{}, error) {
+	encrypt := c.EncryptData
+	if opts.encrypt != nil {
+		encrypt = *opts.encrypt
+	}
+	if !encrypt && !c.sealsEntries() && slide == nil {
+		if storesBytes(c.Cache) {
+			endSerialize := c.stage(stageSerialize)
+			b, err := utils.Serialize(data)
+			endSerialize()
+			if err != nil {
+				return nil, fmt.Errorf("GenericCache.storeValue: can't serialize %T: %s", data, err.Error())
+			}
+			data = b
+		}
+		if b, ok := data.([]byte); ok {
+			c.tagPayloadSize(len(b))
+		}
+		return data, nil
+	}
+	return c.sealEntry(key, data, encrypt, slide)
+}
+
+// getSealed - retrieves an entry written by sealEntry and decodes it into value
+func (c *GenericCache) getSealed(key string, value interface{}) error {
+	var sealed []byte
+	if err := c.Cache.(persistence.CacheStore).Get(key, &sealed); err != nil {
+		if err.Error() != persistence.ErrCacheMiss.Error() {
+			c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+			return err
+		}
+		return persistence.ErrCacheMiss
+	}
+	c.tagPayloadSize(len(sealed))
+	if !isSealedEntry(sealed) && c.EncryptData && !c.SignData && storesBytes(c.Cache) {
+		// written by a release that didn't seal its entries (e.g. by an instance that's still running it)
+		if err := c.openLegacy(key, sealed, value); err != nil {
+			c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+			return err
+		}
+		return nil
+	}
+	return c.openStored(key, sealed, value)
+}
+
+// openLegacy - decodes an entry stored before entries were sealed into value.  Caches with EncryptData only
+// encrypted the Data of GenericCacheEntries (gob encoded, without a header), and stored every other value as is.
+func (c *GenericCache) openLegacy(key string, stored []byte, value interface{}) error {
+	endDeserialize := c.stage(stageDeserialize)
+	err := utils.Deserialize(stored, value)
+	endDeserialize()
+	if err != nil {
+		return fmt.Errorf("GenericCache.openLegacy: key %s can't be decoded into %T: %s", key, value, err.Error())
+	}
+	entry, ok := value.(*GenericCacheEntry)
+	if !ok {
+		return nil
+	}
+	encrypted, ok := entry.Data.([]byte)
+	if !ok {
+		return fmt.Errorf("GenericCache.openLegacy: key %s is not an encrypted entry", key)
+	}
+	data, err := c.decryptEntry(encrypted)
+	if err != nil {
+		return err
+	}
+	defer c.stage(stageDeserialize)()
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry.Data); err != nil {
+		return fmt.Errorf("GenericCache.openLegacy: key %s can't be decoded: %s", key, err.Error())
+	}
+	return nil
+}
+
+// openStored - openEntry for Get, evicting entries that fail their integrity check, and sliding the expiry of
+// sliding entries (which are evicted once they're past their max lifetime)
+func (c *GenericCache) openStored(key string, sealed []byte, value interface{}) error {
+	slide, sliding := sealedSliding(sealed)
+	var ttl time.Duration
+	if sliding {
+		if ttl = slide.next(time.Now()); ttl <= 0 {
+			c.evictEntry(key)
+			return persistence.ErrCacheMiss
+		}
+	}
+	if err := c.openEntry(key, sealed, value); err != nil {
+		c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		if err == ErrIntegrityCheckFailed {
+			c.evictEntry(key)
+		}
+		return err
+	}
+	if sliding {
+		c.touch(key, ttl)
+	}
+	return nil
+}
+
+// getMixed - Get for a cache that doesn't seal all of its entries, so individual entries may or may not be sealed
+// (see WithEntryEncryption).  Returns handled == false when the entry should be read from the store as is (for a
+// value that Get doesn't support, or by a store that can't read it into an interface{}).
+func (c *GenericCache) getMixed(key string, value interface{}) (handled bool, err error) {
+	switch c.Cache.(type) {
+	case *persistence.RedisStore, *ArenaStore:
+		// fetch the raw bytes once and deserialize them ourselves if they're not sealed, so mixed entries don't cost
+		// a second round trip
+		var raw []byte
+		if err := c.Cache.(persistence.CacheStore).Get(key, &raw); err != nil {
+			if err.Error() != persistence.ErrCacheMiss.Error() {
+				c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+				return true, err
+			}
+			return true, persistence.ErrCacheMiss
+		}
+		c.tagPayloadSize(len(raw))
+		if isSealedEntry(raw) {
+			return true, c.openStored(key, raw, value)
+		}
+		if v := reflect.ValueOf(value); v.Kind() != reflect.Ptr {
+			// a non-pointer number only checks that the entry exists
+			return isNumber(v.Kind()), nil
+		}
+		endDeserialize := c.stage(stageDeserialize)
+		err := utils.Deserialize(raw, value)
+		endDeserialize()
+		if err != nil {
+			c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+			return true, err
+		}
+		return true, nil
+	}
+	// read the entry once, sealed or not, so a Get is a single hit or miss (and a single promotion in the eviction
+	// policy)
+	v := reflect.ValueOf(value)
+	ptr := v.Kind() == reflect.Ptr && !v.IsNil()
+	if !ptr && !isNumber(v.Kind()) {
+		return false, nil
+	}
+	var stored interface{}
+	switch c.Cache.(type) {
+	case *InMemoryStore, *ShardedInMemoryStore:
+		var entry GenericCacheEntry
+		if err := c.Cache.(persistence.CacheStore).Get(key, &entry); err != nil {
+			return true, err
+		}
+		if e, ok := value.(*GenericCacheEntry); ok {
+			*e = entry
+			return true, nil
+		}
+		stored = entry.Data
+	default:
+		if err := c.Cache.(persistence.CacheStore).Get(key, &stored); err != nil {
+			if err == persistence.ErrCacheMiss {
+				return true, err
+			}
+			// a store that can't read into an interface{} (e.g. memcached)
+			return false, nil
+		}
+	}
+	if sealed, ok := stored.([]byte); ok && isSealedEntry(sealed) {
+		c.tagPayloadSize(len(sealed))
+		return true, c.openStored(key, sealed, value)
+	}
+	if !ptr {
+		// a non-pointer number only checks that the entry exists
+		return true, nil
+	}
+	if err := assignValue(key, stored, value); err != nil {
+		c.logError(fmt.Sprintf("GenericCache.Get: L%v/T%v error == %s", c.cLevel, c.cType, err.Error()))
+		return true, err
+	}
+	return true, nil
+}
+
+// storedSealed - returns the stored entry for key if it's sealed
+func (c *GenericCache) storedSealed(key string) ([]byte, bool) {
+	var sealed []byte
+	if storesBytes(c.Cache) {
+		if err := c.Cache.(persistence.CacheStore).Get(key, &sealed); err != nil {
+			return nil, false
+		}
+		return sealed, isSealedEntry(sealed)
+	}
+	var stored interface{}
+	if err := c.Cache.(persistence.CacheStore).Get(key, &stored); err != nil {
+		return nil, false
+	}
+	sealed, _ = stored.([]byte)
+	return sealed, isSealedEntry(sealed)
+}
+
+// storesBytes - does the store hold serialized values (so Get can't decode into an interface{})
+func storesBytes(store interface{}) bool {
+	switch store.(type) {
+	case *persistence.RedisStore, *ArenaStore:
+		return true
+	}
+	return false
+}
+
+// evictEntry - remove an entry that failed its integrity check
+func (c *GenericCache) evictEntry(key string) {
+	if err := c.Cache.(persistence.CacheStore).Delete(key); err != nil && err != persistence.ErrCacheMiss {
+		c.logError(fmt.Sprintf("GenericCache.evictEntry: L%v/T%v key == %s error == %s", c.cLevel, c.cType, key, err.Error()))
+	}
+}
+
+// sealedUpdater - a store that can replace a sealed entry with update's result while the key is locked, keeping its
+// expiry (so Increment/Decrement of sealed entries are atomic)
+type sealedUpdater interface {
+	updateSealed(key string, update func(sealed []byte) ([]byte, error)) error
+}
+
+// incrementSealed - Increment/Decrement for sealed entries.  The store can't do this natively since it can't read
+// the value, so the entry is unsealed, added to and resealed while the store holds the key's lock (or in a
+// WATCH/MULTI transaction for Redis), which keeps it atomic.  The entry keeps its encryption and expiry.
+func (c *GenericCache) incrementSealed(key string, n uint64, decrement bool) (uint64, error) {
+	var current uint64
+	update := func(sealed []byte) ([]byte, error) {
+		data, err := c.unsealEntry(key, sealed)
+		if err != nil {
+			return nil, err
+		}
+		// counters are stored as whatever integer type was Set, and gob only decodes signed into signed and unsigned into unsigned
+		var i int64
+		signed := gob.NewDecoder(bytes.NewReader(data)).Decode(&i) == nil
+		if signed {
+			current = uint64(i)
+		} else if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&current); err != nil {
+			return nil, persistence.ErrNotSupport
+		}
+		switch {
+		case !decrement:
+			current += n
+		case n > current:
+			current = 0
+		default:
+			current -= n
+		}
+		var newValue interface{} = current
+		if signed {
+			newValue = int64(current)
+		}
+		var slide *slidingExpiry
+		if s, ok := sealedSliding(sealed); ok {
+			slide = &s
+		}
+		return c.sealEntry(key, newValue, sealedFlags(sealed)&entryFlagEncrypt != 0, slide)
+	}
+	var err error
+	switch store := c.Cache.(type) {
+	case sealedUpdater:
+		err = store.updateSealed(key, update)
+	case *persistence.RedisStore:
+		if pool := redisPoolOf(store); pool != nil {
+			err = updateSealedRedis(pool, key, update)
+		} else {
+			err = persistence.ErrNotSupport
+		}
+	default:
+		err = persistence.ErrNotSupport
+	}
+	if err != nil {
+		if err == ErrIntegrityCheckFailed {
+			c.evictEntry(key)
+		}
+		return 0, err
+	}
+	return current, nil
+}
+
+// updateSealedRedis - replace the entry for key with update's result in a WATCH/MULTI transaction, keeping its TTL.
+// When the key is written by someone else in the meantime, the transaction fails and the update is retried.
+func updateSealedRedis(pool *redis.Pool, key string, update func(sealed []byte) ([]byte, error)) error {
+	conn := pool.Get()
+	defer conn.Close()
+	for {
+		args, err := watchSealed(conn, key, update)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+		conn.Send("MULTI")
+		conn.Send("SET", args...)
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+	}
+}
+
+// watchSealed - WATCH key and return the SET args that replace its entry with update's result and keep its TTL
+func watchSealed(conn redis.Conn, key string, update func(sealed []byte) ([]byte, error)) ([]interface{}, error) {
+	if _, err := conn.Do("WATCH", key); err != nil {
+		return nil, err
+	}
+	sealed, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		return nil, err
+	}
+	if ttl == -2 {
+		return nil, persistence.ErrCacheMiss
+	}
+	updated, err := update(sealed)
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{key, updated}
+	if ttl > 0 {
+		args = append(args, "PX", ttl)
+	}
+	return args, nil
+}
+
+// incrementStored - Increment/Decrement in a cache that doesn't seal all of its entries.  The store's native op is
+// tried first and only when it fails is the entry checked for being sealed (so plain counters don't pay for the check).
+func (c *GenericCache) incrementStored(key string, n uint64, decrement bool) (uint64, error) {
+	var newValue uint64
+	var err error
+	if decrement {
+		newValue, err = c.Cache.(persistence.CacheStore).Decrement(key, n)
+	} else {
+		newValue, err = c.Cache.(persistence.CacheStore).Increment(key, n)
+	}
+	if err == nil || err == persistence.ErrCacheMiss {
+		return newValue, err
+	}
+	if _, sealed := c.storedSealed(key); sealed {
+		return c.incrementSealed(key, n, decrement)
+	}
+	return 0, err
+}
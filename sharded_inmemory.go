@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ShardedInMemoryStore - an InMemoryStore split into independent shards (each with its own locks and eviction
+// policy) so concurrent operations on different keys don't contend.  Keys are hashed to a shard, and maxEntries and
+// max bytes are split evenly between the shards.
+type ShardedInMemoryStore struct {
+	*shardedInMemoryStore
+}
+
+type shardedInMemoryStore struct {
+	shards       []*InMemoryStore
+	DefaultExp   time.Duration
+	janitor      *janitor
+	snapshotFile string
+	registry     *metricsRegistry
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewShardedInMemoryStore - create a new sharded in memory cache with shardCount shards.  It supports the same
+// options as NewInMemoryStore, and there's a single janitor and metric gauge for all the shards.
+func NewShardedInMemoryStore(shardCount int, maxEntries int, defaultExpiration, cleanupInterval time.Duration, createMetric bool, metricLabel string, opt ...Option) (*ShardedInMemoryStore, error) {
+	if shardCount <= 0 {
+		return nil, errors.New("cache.NewShardedInMemoryStore: shardCount must be positive")
+	}
+	if maxEntries < shardCount {
+		return nil, fmt.Errorf("cache.NewShardedInMemoryStore: maxEntries (%d) must be at least the shardCount (%d)", maxEntries, shardCount)
+	}
+	opts := GetOpts(opt...)
+	perTick := opts[optionWithMaxExpiredPerTick].(int)
+	// the snapshot file is for the whole store, not each shard
+	shardOpts := append(append([]Option{}, opt...), WithMaxExpiredPerTick((perTick+shardCount-1)/shardCount), WithSnapshotFile(""))
+	if maxBytes := opts[optionWithMaxBytes].(int64); maxBytes > 0 {
+		shardOpts = append(shardOpts, WithMaxBytes((maxBytes+int64(shardCount)-1)/int64(shardCount)))
+	}
+	c := &shardedInMemoryStore{
+		shards:       make([]*InMemoryStore, shardCount),
+		DefaultExp:   defaultExpiration,
+		snapshotFile: opts[optionWithSnapshotFile].(string),
+	}
+	for i := range c.shards {
+		shard, err := NewInMemoryStore((maxEntries+shardCount-1)/shardCount, defaultExpiration, 0, false, "", shardOpts...)
+		if err != nil {
+			return nil, err
+		}
+		c.shards[i] = shard
+	}
+
+	var metrics *storeMetrics
+	if createMetric {
+		// the shards share the store's counters
+		c.registry = newMetricsRegistry(opts)
+		metrics = newStoreMetrics(c.registry, storeMetricName(metricLabel))
+		for _, s := range c.shards {
+			s.metrics = metrics
+		}
+		label := "inmemory_cache_total_items_cnt"
+		if len(metricLabel) != 0 {
+			label = metricLabel
+		}
+		if err := c.registry.gaugeFunc(
+			func() float64 {
+				return float64(c.Len())
+			},
+			label,
+			fmt.Sprintf("Total count the number of items in the in-memory cache for %s", label)); err != nil {
+			c.registry.unregister()
+			return nil, err
+		}
+		if c.shards[0].budget != nil {
+			bytesLabel := "inmemory_cache_total_bytes"
+			if len(metricLabel) != 0 {
+				bytesLabel = metricLabel + "_bytes"
+			}
+			if err := c.registry.gaugeFunc(
+				func() float64 {
+					var total int64
+					for _, s := range c.shards {
+						total += s.budget.Total()
+					}
+					return float64(total)
+				},
+				bytesLabel,
+				fmt.Sprintf("Total cost in bytes of the items in the in-memory cache for %s", label)); err != nil {
+				c.registry.unregister()
+				return nil, err
+			}
+		}
+	}
+
+	// see NewInMemoryStore for why the janitor runs on c and the finalizer is set on C
+	C := &ShardedInMemoryStore{c}
+	if c.snapshotFile != "" {
+		restoreSnapshotFile(c.snapshotFile, C.Restore, opts[optionWithSnapshotLogger].(*logrus.Entry))
+	}
+	if cleanupInterval > 0 {
+		c.janitor = runJanitor(c, cleanupInterval, metrics.janitorObserver())
+		runtime.SetFinalizer(C, stopShardedJanitor)
+	}
+	return C, nil
+}
+
+func stopShardedJanitor(c *ShardedInMemoryStore) {
+	c.janitor.signal()
+}
+
+// shard - the shard for key.  This uses FNV-1 while the shards' key locks use FNV-1a, so the keys of a shard are
+// still spread over all of its lock stripes.
+func (c *shardedInMemoryStore) shard(key string) *InMemoryStore {
+	return c.shards[fnv32(key)%uint32(len(c.shards))]
+}
+
+// ShardCount - the number of shards
+func (c *ShardedInMemoryStore) ShardCount() int {
+	return len(c.shards)
+}
+
+// NewGenericCacheEntry - create a new in memory cache entry
+func (c *ShardedInMemoryStore) NewGenericCacheEntry(data interface{}, exp time.Duration) (newEntry GenericCacheEntry, err error) {
+	return c.shards[0].NewGenericCacheEntry(data, exp)
+}
+
+// Get - Get an entry (see InMemoryStore.Get)
+func (c *ShardedInMemoryStore) Get(key string, value interface{}) error {
+	return c.shard(key).Get(key, value)
+}
+
+// Set - set an entry
+func (c *ShardedInMemoryStore) Set(key string, value interface{}, exp time.Duration) error {
+	return c.shard(key).Set(key, value, exp)
+}
+
+// SetWithOptions - set an entry using the per entry options (see InMemoryStore.SetWithOptions)
+func (c *ShardedInMemoryStore) SetWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	return c.shard(key).SetWithOptions(key, value, exp, opt...)
+}
+
+// Add - add an entry
+func (c *ShardedInMemoryStore) Add(key string, value interface{}, exp time.Duration) error {
+	return c.shard(key).Add(key, value, exp)
+}
+
+// AddWithOptions - add an entry using the per entry options (see InMemoryStore.AddWithOptions)
+func (c *ShardedInMemoryStore) AddWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	return c.shard(key).AddWithOptions(key, value, exp, opt...)
+}
+
+// Replace - replace an entry
+func (c *ShardedInMemoryStore) Replace(key string, value interface{}, exp time.Duration) error {
+	return c.shard(key).Replace(key, value, exp)
+}
+
+// ReplaceWithOptions - replace an entry using the per entry options (see InMemoryStore.ReplaceWithOptions)
+func (c *ShardedInMemoryStore) ReplaceWithOptions(key string, value interface{}, exp time.Duration, opt ...EntryOption) error {
+	return c.shard(key).ReplaceWithOptions(key, value, exp, opt...)
+}
+
+// Update - update an entry
+func (c *ShardedInMemoryStore) Update(key string, entry GenericCacheEntry) error {
+	return c.shard(key).Update(key, entry)
+}
+
+// Delete - delete an entry
+func (c *ShardedInMemoryStore) Delete(key string) error {
+	return c.shard(key).Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (c *ShardedInMemoryStore) Increment(key string, n uint64) (uint64, error) {
+	return c.shard(key).Increment(key, n)
+}
+
+// updateSealed (see sealedUpdater)
+func (c *ShardedInMemoryStore) updateSealed(key string, update func(sealed []byte) ([]byte, error)) error {
+	return c.shard(key).updateSealed(key, update)
+}
+
+// Decrement (see CacheStore interface)
+func (c *ShardedInMemoryStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.shard(key).Decrement(key, n)
+}
+
+// Keys - get all the keys (from every shard)
+func (c *ShardedInMemoryStore) Keys() []interface{} {
+	var keys []interface{}
+	for _, s := range c.shards {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+// Len - get the current count of entries in the cache (in every shard)
+func (c *shardedInMemoryStore) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+// OnEvict - register a handler that's called whenever an entry leaves any of the shards (see
+// InMemoryStore.OnEvict)
+func (c *ShardedInMemoryStore) OnEvict(h EvictionHandler) {
+	for _, s := range c.shards {
+		s.OnEvict(h)
+	}
+}
+
+// Flush (see CacheStore interface)
+func (c *ShardedInMemoryStore) Flush() error {
+	for _, s := range c.shards {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpired - Delete the expired items from every shard (see InMemoryStore.DeleteExpired).
+func (c *shardedInMemoryStore) DeleteExpired() {
+	for _, s := range c.shards {
+		s.DeleteExpired()
+	}
+}
+
+// Snapshot - write the unexpired entries of all the shards to w (see InMemoryStore.Snapshot).  The snapshot can be
+// restored into a store with any number of shards, or into an InMemoryStore.
+func (c *ShardedInMemoryStore) Snapshot(w io.Writer) error {
+	stores := make([]*inMemoryStore, len(c.shards))
+	for i, s := range c.shards {
+		stores[i] = s.inMemoryStore
+	}
+	return writeSnapshot(w, stores...)
+}
+
+// Restore - load the entries of a Snapshot into the shards (see InMemoryStore.Restore)
+func (c *ShardedInMemoryStore) Restore(r io.Reader) error {
+	defer func() {
+		for _, s := range c.shards {
+			s.enforceBudget()
+			s.evictions.dispatch()
+		}
+	}()
+	return readSnapshot(r, func(key string, entry GenericCacheEntry) error {
+		return c.shard(key).restore(key, entry)
+	})
+}
+
+// SaveSnapshot - write a Snapshot to the store's WithSnapshotFile (call it on shutdown)
+func (c *ShardedInMemoryStore) SaveSnapshot() error {
+	return saveSnapshotFile(c.snapshotFile, c.Snapshot)
+}
+
+// Close - Shutdown, waiting at most defaultCloseTimeout
+func (c *ShardedInMemoryStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown - stop the janitor, dispatch pending OnEvict callbacks, unregister the store's metrics and save the
+// WithSnapshotFile snapshot (see InMemoryStore.Shutdown)
+func (c *ShardedInMemoryStore) Shutdown(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		var err error
+		if c.janitor != nil {
+			err = c.janitor.Stop(ctx)
+		}
+		for _, s := range c.shards {
+			if shardErr := s.Shutdown(ctx); shardErr != nil && err == nil {
+				err = shardErr
+			}
+		}
+		c.registry.unregister()
+		if c.snapshotFile != "" {
+			if snapErr := c.SaveSnapshot(); snapErr != nil && err == nil {
+				err = snapErr
+			}
+		}
+		c.closeErr = err
+	})
+	return c.closeErr
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+var newShardedInMemoryStore = func(_ *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+	c, err := NewShardedInMemoryStore(8, 100, defaultExpiration, defCleanupInterval, false, "")
+	if err != nil {
+		panic("can't create sharded inmemory store: " + err.Error())
+	}
+	return c
+}
+
+func TestShardedInMemoryStore_TypicalGetSet(t *testing.T) {
+	inMemTypicalGetSet(t, newShardedInMemoryStore)
+	anyTypes(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryStore_Expiration(t *testing.T) {
+	inMemExpiration(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryStore_IncrDecr(t *testing.T) {
+	incrDecr(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryStore_EmptyCache(t *testing.T) {
+	emptyCache(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryStore_Add(t *testing.T) {
+	testAdd(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryStore_KeysLenFlush(t *testing.T) {
+	c := newShardedInMemoryStore(t, time.Hour).(*ShardedInMemoryStore)
+	for i := 0; i < 50; i++ {
+		if err := c.Set(fmt.Sprintf("key-%d", i), i, persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+	}
+	if c.Len() != 50 {
+		t.Errorf("expected 50 entries, got %d", c.Len())
+	}
+	if len(c.Keys()) != 50 {
+		t.Errorf("expected 50 keys, got %d", len(c.Keys()))
+	}
+	used := 0
+	for _, s := range c.shards {
+		if s.Len() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("expected the keys to be spread over the shards, got %d shards used", used)
+	}
+	if err := c.Flush(); err != nil || c.Len() != 0 {
+		t.Errorf("expected an empty store after a flush, got %d - %v", c.Len(), err)
+	}
+	if _, err := NewShardedInMemoryStore(8, 4, time.Hour, 0, false, ""); err == nil {
+		t.Errorf("expected an error for fewer entries than shards")
+	}
+}
+
+func TestShardedInMemoryStore_MaxBytes(t *testing.T) {
+	cost := func(key string, value interface{}) int64 { return 100 }
+	c, err := NewShardedInMemoryStore(4, 1000, time.Hour, 0, false, "", WithMaxBytes(4000), WithCostFunc(cost))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := c.Set(fmt.Sprintf("key-%d", i), i, persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+	}
+	for _, s := range c.shards {
+		if total := s.budget.Total(); total > 1000 {
+			t.Errorf("expected each shard to be under its 1000 bytes, got %d", total)
+		}
+	}
+}
+
+func TestShardedInMemoryStore_Concurrent(t *testing.T) {
+	c := newShardedInMemoryStore(t, time.Hour).(*ShardedInMemoryStore)
+	if err := c.Set("counter", 0, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting counter: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Increment("counter", 1)
+				c.Set(fmt.Sprintf("key-%d-%d", i, j), j, persistence.DEFAULT)
+				c.Get(fmt.Sprintf("key-%d-%d", i, j), new(int))
+			}
+		}(i)
+	}
+	wg.Wait()
+	var n int
+	if err := c.Get("counter", &n); err != nil || n != 1000 {
+		t.Errorf("expected 1000, got %d - %v", n, err)
+	}
+}
+
+func TestGenericCache_ShardedInMemoryStore(t *testing.T) {
+	store := newShardedInMemoryStore(t, time.Hour)
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), true)
+	if err := c.Set("counter", 10, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, err := c.Increment("counter", 5); err != nil || n != 15 {
+		t.Errorf("expected 15, got %d - %v", n, err)
+	}
+	found, entry, err := c.Exists("missing")
+	if found || err != persistence.ErrCacheMiss {
+		t.Errorf("expected a miss, got %v %v - %v", found, entry, err)
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+)
+
+// slidingExpiryLen - the size of a slidingExpiry in a sealed entry: the idle timeout and the deadline in ms
+const slidingExpiryLen = 16
+
+// slidingExpiry - how a sliding entry's expiry is extended when it's read: by idle, but never past the deadline
+// (epoc in ms, 0 when the entry has no max lifetime)
+type slidingExpiry struct {
+	idle     time.Duration
+	deadline int64
+}
+
+// appendTo - append the slidingExpiry to a sealed entry's header
+func (s slidingExpiry) appendTo(b []byte) []byte {
+	var buf [slidingExpiryLen]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(s.idle/time.Millisecond))
+	binary.BigEndian.PutUint64(buf[8:], uint64(s.deadline))
+	return append(b, buf[:]...)
+}
+
+// sealedSliding - the slidingExpiry of a sealed entry, if it's a sliding entry
+func sealedSliding(sealed []byte) (slidingExpiry, bool) {
+	if !isSealedEntry(sealed) || sealedFlags(sealed)&entryFlagSliding == 0 || len(sealed) < entryHeaderLen+slidingExpiryLen {
+		return slidingExpiry{}, false
+	}
+	b := sealed[entryHeaderLen:]
+	return slidingExpiry{
+		idle:     time.Duration(binary.BigEndian.Uint64(b[:8])) * time.Millisecond,
+		deadline: int64(binary.BigEndian.Uint64(b[8:16])),
+	}, true
+}
+
+// next - the expiry of an entry that's read at now (<= 0 when it's past its deadline)
+func (s slidingExpiry) next(now time.Time) time.Duration {
+	ttl := s.idle
+	if s.deadline != 0 {
+		if left := time.UnixMilli(s.deadline).Sub(now); left < ttl {
+			ttl = left
+		}
+	}
+	return ttl
+}
+
+// WithEntrySliding - make this entry's expiry slide (true) or stay absolute (false), overriding the cache's
+// WithSlidingExpiration
+func WithEntrySliding(sliding bool) EntryOption {
+	return func(o *entryOptions) {
+		o.sliding = &sliding
+	}
+}
+
+// WithEntryMaxLifetime - the max lifetime of this sliding entry (0 means none), overriding the cache's
+// WithMaxLifetime
+func WithEntryMaxLifetime(maxLifetime time.Duration) EntryOption {
+	return func(o *entryOptions) {
+		o.maxLifetime = &maxLifetime
+	}
+}
+
+// slidingFor - the slidingExpiry of an entry set with exp (nil when it doesn't slide) and the expiry to set it with,
+// which is capped by its max lifetime.  Entries that never expire don't slide.
+func (c *GenericCache) slidingFor(exp time.Duration, opts entryOptions) (*slidingExpiry, time.Duration) {
+	sliding := c.sliding
+	if opts.sliding != nil {
+		sliding = *opts.sliding
+	}
+	if !sliding || exp <= 0 {
+		return nil, exp
+	}
+	maxLifetime := c.maxLifetime
+	if opts.maxLifetime != nil {
+		maxLifetime = *opts.maxLifetime
+	}
+	slide := &slidingExpiry{idle: exp}
+	if maxLifetime > 0 {
+		slide.deadline = time.Now().Add(maxLifetime).UnixMilli()
+		if exp > maxLifetime {
+			exp = maxLifetime
+		}
+		if exp < time.Second {
+			// the stores keep expiries in seconds, and Get enforces the deadline
+			exp = time.Second
+		}
+	}
+	return slide, exp
+}
+
+// touch - extend the expiry of a sliding entry that was read.  Errors are only logged, since the entry was read.
+func (c *GenericCache) touch(key string, ttl time.Duration) {
+	var err error
+	switch store := c.Cache.(type) {
+	case interface {
+		Touch(key string, exp time.Duration) error
+	}:
+		err = store.Touch(key, ttl)
+	case *persistence.RedisStore:
+		if pool := redisPoolOf(store); pool != nil {
+			err = pexpire(pool, key, ttl)
+		} else {
+			err = store.ExpireAt(key, uint64(time.Now().Add(ttl).Unix()))
+		}
+	default:
+		err = persistence.ErrNotSupport
+	}
+	if err != nil && err != persistence.ErrCacheMiss {
+		c.logError(fmt.Sprintf("GenericCache.touch: L%v/T%v key == %s error == %s", c.cLevel, c.cType, key, err.Error()))
+	}
+}
+
+// pexpire - set the expiry of key with PEXPIRE
+func pexpire(pool *redis.Pool, key string, ttl time.Duration) error {
+	conn := pool.Get()
+	defer conn.Close()
+	ok, err := redis.Bool(conn.Do("PEXPIRE", key, int64(ttl/time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Touch - set the expiry of an entry in place, without copying or re-costing its data.  It isn't an access, so the
+// eviction policy doesn't promote the entry or count it (a custom EvictionPolicy without an Update(key, value) bool
+// method has the entry re-added instead).
+func (c *InMemoryStore) Touch(key string, exp time.Duration) error {
+	defer c.evictions.dispatch()
+	defer c.lockKey(key).Unlock()
+	v, ok := c.policy.Peek(key)
+	if !ok {
+		return persistence.ErrCacheMiss
+	}
+	entry := v.(GenericCacheEntry)
+	if entry.Expired() {
+		c.remove(key, EvictReasonExpired)
+		c.metrics.expired(expiredOnRead)
+		return persistence.ErrCacheMiss
+	}
+	entry.ExpiresAt = 0
+	if exp != persistence.FOREVER {
+		entry.ExpiresAt = time.Now().Unix() + int64(exp/time.Second)
+	}
+	c.book.Lock()
+	defer c.book.Unlock()
+	if u, ok := c.policy.(policyUpdater); ok {
+		ok = u.Update(key, entry)
+	} else if ok = c.policy.Contains(key); ok {
+		c.policy.Add(key, entry)
+	}
+	if !ok {
+		// the policy evicted it since the Peek
+		return persistence.ErrCacheMiss
+	}
+	c.expiry.set(key, entry.ExpiresAt)
+	return nil
+}
+
+// Touch - set the expiry of an entry in place (see InMemoryStore.Touch)
+func (c *ShardedInMemoryStore) Touch(key string, exp time.Duration) error {
+	return c.shard(key).Touch(key, exp)
+}
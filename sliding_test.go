@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestSlidingExpiry(t *testing.T) {
+	now := time.Now()
+	s := slidingExpiry{idle: time.Minute, deadline: now.Add(30 * time.Second).UnixMilli()}
+	sealed := s.appendTo([]byte{0x00, 'g', 'c', entryVersion, entryFlagSliding})
+	got, ok := sealedSliding(sealed)
+	if !ok || got != s {
+		t.Fatalf("expected %+v, got %+v", s, got)
+	}
+	if ttl := got.next(now); ttl > 30*time.Second || ttl < 29*time.Second {
+		t.Errorf("expected the deadline to cap the ttl, got %v", ttl)
+	}
+	if ttl := got.next(now.Add(time.Minute)); ttl > 0 {
+		t.Errorf("expected a ttl past the deadline to be <= 0, got %v", ttl)
+	}
+	if _, ok := sealedSliding([]byte{0x00, 'g', 'c', entryVersion, 0}); ok {
+		t.Errorf("expected an entry without entryFlagSliding not to slide")
+	}
+}
+
+func TestGenericCache_SlidingInMemory(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+		c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), encrypt, WithSlidingExpiration(true))
+		if err := c.Set("session", "data", 10*time.Minute); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// as if the entry hasn't been read for a while
+		store.Touch("session", time.Minute)
+		var value string
+		if err := c.Get("session", &value); err != nil || value != "data" {
+			t.Fatalf("expected data, got %q and %v", value, err)
+		}
+		info, _ := store.Inspect("session")
+		if remaining := time.Until(time.Unix(info.ExpiresAt, 0)); remaining < 9*time.Minute {
+			t.Errorf("expected the read to slide the expiry back to 10m, got %v", remaining)
+		}
+
+		c.SetWithOptions("absolute", "data", 10*time.Minute, WithEntrySliding(false))
+		store.Touch("absolute", time.Minute)
+		c.Get("absolute", &value)
+		info, _ = store.Inspect("absolute")
+		if remaining := time.Until(time.Unix(info.ExpiresAt, 0)); remaining > time.Minute+time.Second {
+			t.Errorf("expected an absolute expiry, got %v", remaining)
+		}
+	}
+}
+
+func TestInMemoryStore_TouchIsNotAnAccess(t *testing.T) {
+	for name, policy := range map[string]PolicyFactory{"lru": NewLRUPolicy, "arc": NewARCPolicy, "lfu": NewLFUPolicy, "tinylfu": NewTinyLFUPolicy} {
+		store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "", WithEvictionPolicy(policy))
+		store.Set("a", "A", time.Hour)
+		store.Set("b", "B", time.Hour)
+		var value string
+		store.Get("b", &value)
+		for i := 0; i < 3; i++ {
+			if err := store.Touch("a", time.Minute); err != nil {
+				t.Fatalf("%s: unexpected error: %s", name, err)
+			}
+		}
+		if keys := store.Keys(); len(keys) != 2 || keys[0] != "a" {
+			t.Errorf("%s: expected Touch not to promote a, got %v", name, keys)
+		}
+		info, _ := store.Inspect("a")
+		if remaining := time.Until(time.Unix(info.ExpiresAt, 0)); remaining > time.Minute || remaining < 58*time.Second {
+			t.Errorf("%s: expected Touch to set the expiry, got %v", name, remaining)
+		}
+	}
+}
+
+func TestGenericCache_SlidingMaxLifetime(t *testing.T) {
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("test"), false, WithMaxLifetime(time.Hour))
+	c.SetWithOptions("capped", "data", 10*time.Minute, WithEntrySliding(true), WithEntryMaxLifetime(time.Second))
+	info, _ := store.Inspect("capped")
+	if remaining := time.Until(time.Unix(info.ExpiresAt, 0)); remaining > time.Second {
+		t.Errorf("expected the expiry to be capped by the max lifetime, got %v", remaining)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	var value string
+	if err := c.Get("capped", &value); err != persistence.ErrCacheMiss {
+		t.Errorf("expected a miss past the max lifetime, got %q and %v", value, err)
+	}
+}
+
+func TestGenericCache_SlidingRedis(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer m.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", m.Addr()) }}
+	c := NewCacheWithPool(NewRedisStore(pool, time.Hour), Writable, L2, sharedSecret, defExpSeconds, []byte("test"), false,
+		WithSlidingExpiration(true), WithMaxLifetime(time.Hour))
+	defer c.Close()
+	if err := c.Set("session", map[string]string{"user": "u1"}, 10*time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	m.SetTTL("session", time.Minute)
+	var value map[string]string
+	if err := c.Get("session", &value); err != nil || value["user"] != "u1" {
+		t.Fatalf("expected the session, got %v and %v", value, err)
+	}
+	if ttl := m.TTL("session"); ttl != 10*time.Minute {
+		t.Errorf("expected the read to PEXPIRE the key to 10m, got %v", ttl)
+	}
+}
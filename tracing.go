@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// the tags of a GenericCache's spans.  Keys are never tagged (they may hold personal data), only the cache's
+// KeyPrefix.
+const (
+	tagOperation   = "cache.operation"
+	tagLevel       = "cache.level"
+	tagType        = "cache.type"
+	tagKeyPrefix   = "cache.key_prefix"
+	tagHit         = "cache.hit"
+	tagPayloadSize = "cache.payload_size"
+	spanComponent  = "go-cache"
+)
+
+// WithContext - the cache, for operations that are part of ctx: every operation of the returned cache is traced as
+// a child span of the opentracing span in ctx (when there is one), with extra spans for encrypting, decrypting,
+// serializing and deserializing.  The returned cache shares everything else (its stores, metrics, Shutdown, etc)
+// with c, so it's cheap to make one per request.
+func (c *GenericCache) WithContext(ctx context.Context) *GenericCache {
+	traced := *c
+	traced.ctx = ctx
+	return &traced
+}
+
+// opSpan - the span of an operation (a nil *opSpan is an operation that isn't traced)
+type opSpan struct {
+	span   opentracing.Span
+	lookup bool
+}
+
+// startSpan - start the span of an op when the cache has a context with a span.  It returns the cache to use for the
+// rest of the op, whose context has the op's span (so the op's stages and nested ops are its children).
+func (c *GenericCache) startSpan(op string) (*GenericCache, *opSpan) {
+	if c.ctx == nil {
+		return c, nil
+	}
+	parent := opentracing.SpanFromContext(c.ctx)
+	if parent == nil {
+		return c, nil
+	}
+	span := parent.Tracer().StartSpan("cache."+op,
+		opentracing.ChildOf(parent.Context()),
+		opentracing.Tag{Key: string(ext.Component), Value: spanComponent},
+		opentracing.Tag{Key: tagOperation, Value: op},
+		opentracing.Tag{Key: tagLevel, Value: fmt.Sprintf("L%d", c.cLevel)},
+		opentracing.Tag{Key: tagType, Value: cacheTypeLabel(c.cType)},
+		opentracing.Tag{Key: tagKeyPrefix, Value: string(c.KeyPrefix)},
+	)
+	traced := c.WithContext(opentracing.ContextWithSpan(c.ctx, span))
+	return traced, &opSpan{span: span, lookup: lookupOps[op]}
+}
+
+// finish - tag the op's result and finish its span.  It's deferred with the op's (named) err, like
+// cacheMetrics.observe.
+func (s *opSpan) finish(err *error) {
+	if s == nil {
+		return
+	}
+	switch {
+	case *err == nil:
+		if s.lookup {
+			s.span.SetTag(tagHit, true)
+		}
+	case *err == persistence.ErrCacheMiss:
+		s.span.SetTag(tagHit, false)
+	default:
+		ext.Error.Set(s.span, true)
+		s.span.LogFields(otlog.Error(*err))
+	}
+	s.span.Finish()
+}
+
+// tagPayloadSize - tag the span of the current op with the size of the entry it stored or read
+func (c *GenericCache) tagPayloadSize(size int) {
+	if c.ctx == nil {
+		return
+	}
+	if span := opentracing.SpanFromContext(c.ctx); span != nil {
+		span.SetTag(tagPayloadSize, size)
+	}
+}
+
+// stage - start timing (and, for a traced op, tracing) a stage of the current op.  It returns the func that ends it.
+func (c *GenericCache) stage(stage string) func() {
+	start := time.Now()
+	var span opentracing.Span
+	if c.ctx != nil {
+		if parent := opentracing.SpanFromContext(c.ctx); parent != nil {
+			span = parent.Tracer().StartSpan("cache."+stage, opentracing.ChildOf(parent.Context()),
+				opentracing.Tag{Key: string(ext.Component), Value: spanComponent})
+		}
+	}
+	return func() {
+		c.metrics.observeStage(stage, start)
+		if span != nil {
+			span.Finish()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// testTracer - a tracer that records its spans (the vendored opentracing-go doesn't include mocktracer)
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+	next  int
+}
+
+type testSpanContext struct{ id int }
+
+func (testSpanContext) ForeachBaggageItem(func(k, v string) bool) {}
+
+type testSpan struct {
+	tracer   *testTracer
+	name     string
+	id       int
+	parentID int
+	tags     map[string]interface{}
+	logs     []otlog.Field
+	finished bool
+}
+
+func (t *testTracer) StartSpan(name string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var o opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&o)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	s := &testSpan{tracer: t, name: name, id: t.next, tags: map[string]interface{}{}}
+	for k, v := range o.Tags {
+		s.tags[k] = v
+	}
+	for _, ref := range o.References {
+		s.parentID = ref.ReferencedContext.(testSpanContext).id
+	}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (t *testTracer) Inject(opentracing.SpanContext, interface{}, interface{}) error { return nil }
+
+func (t *testTracer) Extract(interface{}, interface{}) (opentracing.SpanContext, error) {
+	return nil, opentracing.ErrSpanContextNotFound
+}
+
+// byName - the finished spans named name
+func (t *testTracer) byName(name string) []*testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []*testSpan
+	for _, s := range t.spans {
+		if s.name == name && s.finished {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func (s *testSpan) Finish()                                       { s.finished = true }
+func (s *testSpan) FinishWithOptions(opentracing.FinishOptions)   { s.finished = true }
+func (s *testSpan) Context() opentracing.SpanContext              { return testSpanContext{id: s.id} }
+func (s *testSpan) SetOperationName(name string) opentracing.Span { s.name = name; return s }
+func (s *testSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.tags[key] = value
+	return s
+}
+func (s *testSpan) LogFields(fields ...otlog.Field)                { s.logs = append(s.logs, fields...) }
+func (s *testSpan) LogKV(...interface{})                           {}
+func (s *testSpan) SetBaggageItem(string, string) opentracing.Span { return s }
+func (s *testSpan) BaggageItem(string) string                      { return "" }
+func (s *testSpan) Tracer() opentracing.Tracer                     { return s.tracer }
+func (s *testSpan) LogEvent(string)                                {}
+func (s *testSpan) LogEventWithPayload(string, interface{})        {}
+func (s *testSpan) Log(opentracing.LogData)                        {}
+
+func TestGenericCache_Tracing(t *testing.T) {
+	tracer := &testTracer{}
+	root := tracer.StartSpan("request")
+	ctx := opentracing.ContextWithSpan(context.Background(), root)
+
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("trace_test"), true)
+	traced := c.WithContext(ctx)
+
+	const key = "user-secret-key"
+	var v int
+	if err := traced.Set(key, 42, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := traced.Get(key, &v); err != nil || v != 42 {
+		t.Fatalf("expected 42, got %v (%v)", v, err)
+	}
+	if err := traced.Get("missing", &v); err == nil {
+		t.Fatalf("expected a miss")
+	}
+	if err := traced.Add(key, 1, time.Minute); err == nil {
+		t.Fatalf("expected an error adding an existing key")
+	}
+
+	sets := tracer.byName("cache." + opSet)
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 set span, got %d", len(sets))
+	}
+	set := sets[0]
+	if set.parentID != root.(*testSpan).id {
+		t.Errorf("expected the set span to be a child of the request span")
+	}
+	expected := map[string]interface{}{
+		tagOperation: opSet,
+		tagLevel:     "L1",
+		tagType:      "Writable",
+		tagKeyPrefix: "trace_test",
+		"component":  spanComponent,
+	}
+	for k, want := range expected {
+		if got := set.tags[k]; got != want {
+			t.Errorf("set span tag %s: expected %v, got %v", k, want, got)
+		}
+	}
+	if size, ok := set.tags[tagPayloadSize].(int); !ok || size <= 0 {
+		t.Errorf("expected a payload size on the set span, got %v", set.tags[tagPayloadSize])
+	}
+	if _, ok := set.tags[tagHit]; ok {
+		t.Errorf("set isn't a lookup, it shouldn't be tagged with %s", tagHit)
+	}
+
+	gets := tracer.byName("cache." + opGet)
+	if len(gets) != 2 {
+		t.Fatalf("expected 2 get spans, got %d", len(gets))
+	}
+	if gets[0].tags[tagHit] != true || gets[1].tags[tagHit] != false {
+		t.Errorf("expected a hit then a miss, got %v and %v", gets[0].tags[tagHit], gets[1].tags[tagHit])
+	}
+	if gets[1].tags["error"] != nil {
+		t.Errorf("a miss isn't an error")
+	}
+
+	adds := tracer.byName("cache." + opAdd)
+	if len(adds) != 1 || adds[0].tags["error"] != true || len(adds[0].logs) == 0 {
+		t.Fatalf("expected the add span to be tagged and logged as an error")
+	}
+
+	// the stages are children of their op
+	children := func(parent *testSpan, name string) int {
+		n := 0
+		for _, s := range tracer.byName(name) {
+			if s.parentID == parent.id {
+				n++
+			}
+		}
+		return n
+	}
+	if children(set, "cache."+stageSerialize) != 1 || children(set, "cache."+stageEncrypt) != 1 {
+		t.Errorf("expected serialize and encrypt spans under the set span")
+	}
+	if children(gets[0], "cache."+stageDecrypt) != 1 || children(gets[0], "cache."+stageDeserialize) != 1 {
+		t.Errorf("expected decrypt and deserialize spans under the get span")
+	}
+
+	// the raw key is never in a span
+	tracer.mu.Lock()
+	for _, s := range tracer.spans {
+		for k, v := range s.tags {
+			if strings.Contains(fmt.Sprint(v), key) {
+				t.Errorf("span %s tag %s has the raw key: %v", s.name, k, v)
+			}
+		}
+	}
+	tracer.mu.Unlock()
+}
+
+func TestGenericCache_TracingWithoutSpan(t *testing.T) {
+	store, _ := NewInMemoryStore(maxEntries, time.Hour, 0, false, "")
+	c := NewCacheWithPool(store, Writable, L1, sharedSecret, defExpSeconds, []byte("trace_test_nospan"), false)
+
+	var v int
+	c.Set("a", 1, time.Minute)
+	c.Get("a", &v)
+	traced := c.WithContext(context.Background())
+	traced.Set("a", 1, time.Minute)
+	if err := traced.Get("a", &v); err != nil || v != 1 {
+		t.Fatalf("expected 1, got %v (%v)", v, err)
+	}
+	if c.ctx != nil {
+		t.Fatalf("WithContext shouldn't change the cache it's called on")
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+// Typed - a type safe facade over a GenericCache for values of type T.  Values go through the GenericCache, so
+// they're serialized, encrypted and signed the same way as any other entry.
+type Typed[T any] struct {
+	cache *GenericCache
+}
+
+// NewTyped - creates a Typed facade for values of type T over the cache
+func NewTyped[T any](c *GenericCache) *Typed[T] {
+	return &Typed[T]{cache: c}
+}
+
+// Cache - the underlying GenericCache
+func (t *Typed[T]) Cache() *GenericCache {
+	return t.cache
+}
+
+// Get - get the value for key.  found is false (with a nil err) when it's not in the cache.
+func (t *Typed[T]) Get(key string) (value T, found bool, err error) {
+	if err := t.cache.Get(key, &value); err != nil {
+		var zero T
+		if err == persistence.ErrCacheMiss {
+			return zero, false, nil
+		}
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Set - set the value for key (see GenericCache.SetWithOptions)
+func (t *Typed[T]) Set(key string, value T, ttl time.Duration, opt ...EntryOption) error {
+	return t.cache.SetWithOptions(key, value, ttl, opt...)
+}
+
+// Delete - delete the value for key
+func (t *Typed[T]) Delete(key string) error {
+	return t.cache.Delete(key)
+}
+
+// GetOrLoad - read-through get: on a miss the loader is called and its value is cached for ttl.  Cache errors are
+// logged and treated as a miss, so the cache can't keep a caller from getting the value.
+func (t *Typed[T]) GetOrLoad(key string, ttl time.Duration, loader func(key string) (T, error)) (T, error) {
+	value, found, err := t.Get(key)
+	if err != nil {
+		t.cache.logError(fmt.Sprintf("Typed.GetOrLoad: L%v/T%v key == %s error == %s", t.cache.cLevel, t.cache.cType, key, err.Error()))
+	}
+	if found {
+		return value, nil
+	}
+	value, err = loader(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if err := t.Set(key, value, ttl); err != nil {
+		t.cache.logError(fmt.Sprintf("Typed.GetOrLoad: L%v/T%v key == %s error == %s", t.cache.cLevel, t.cache.cType, key, err.Error()))
+	}
+	return value, nil
+}
+
+// GetMany - get the values for keys.  Only keys that were found are in the returned map.
+func (t *Typed[T]) GetMany(keys []string) (map[string]T, error) {
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		value, found, err := t.Get(key)
+		if err != nil {
+			return values, err
+		}
+		if found {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// SetMany - set all the values with the same ttl
+func (t *Typed[T]) SetMany(values map[string]T, ttl time.Duration, opt ...EntryOption) error {
+	for key, value := range values {
+		if err := t.Set(key, value, ttl, opt...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetManyOrLoad - read-through GetMany: the loader is called once with all the keys that missed and the values it
+// returns are cached for ttl.  Keys the loader doesn't return are left out of the result.
+func (t *Typed[T]) GetManyOrLoad(keys []string, ttl time.Duration, loader func(missing []string) (map[string]T, error)) (map[string]T, error) {
+	values := make(map[string]T, len(keys))
+	var missing []string
+	for _, key := range keys {
+		value, found, err := t.Get(key)
+		if err != nil {
+			t.cache.logError(fmt.Sprintf("Typed.GetManyOrLoad: L%v/T%v key == %s error == %s", t.cache.cLevel, t.cache.cType, key, err.Error()))
+		}
+		if found {
+			values[key] = value
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+	loaded, err := loader(missing)
+	if err != nil {
+		return values, err
+	}
+	for key, value := range loaded {
+		values[key] = value
+		if err := t.Set(key, value, ttl); err != nil {
+			t.cache.logError(fmt.Sprintf("Typed.GetManyOrLoad: L%v/T%v key == %s error == %s", t.cache.cLevel, t.cache.cType, key, err.Error()))
+		}
+	}
+	return values, nil
+}
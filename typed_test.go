@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTyped_GetSet(t *testing.T) {
+	r, err := initTestRedis(t)
+	if err != nil {
+		t.Fatal("Unable to init test redis: ", err)
+	}
+	defer r.Close()
+
+	typedGetSet(t, newGenericCache(t, time.Hour))
+	typedGetSet(t, newGenericStoreRedisEncrypted(t, time.Hour).(*GenericCache))
+	typedGetSet(t, newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache))
+	typedGetSet(t, newExpiryLRUInMemoryStoreEncrypted(t, time.Hour).(*GenericCache))
+}
+
+func typedGetSet(t *testing.T, c *GenericCache) {
+	structs := NewTyped[testStruct](c)
+	s := testStruct{Name: "foo", Count: 2, Tags: []string{"a"}}
+	if err := structs.Set("typed-struct", s, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, found, err := structs.Get("typed-struct")
+	if err != nil || !found || got.Name != "foo" || got.Count != 2 || len(got.Tags) != 1 {
+		t.Errorf("expected %v, got %v - %v %v", s, got, found, err)
+	}
+	if _, found, err := structs.Get("typed-missing"); found || err != nil {
+		t.Errorf("expected a miss, got %v - %v", found, err)
+	}
+
+	ints := NewTyped[int64](c)
+	if err := ints.Set("typed-int", 42, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if i, found, err := ints.Get("typed-int"); err != nil || !found || i != 42 {
+		t.Errorf("expected 42, got %d - %v %v", i, found, err)
+	}
+}
+
+func TestTyped_GetOrLoad(t *testing.T) {
+	c := newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache)
+	strs := NewTyped[string](c)
+	loads := 0
+	loader := func(key string) (string, error) {
+		loads++
+		return "loaded-" + key, nil
+	}
+	for i := 0; i < 2; i++ {
+		v, err := strs.GetOrLoad("key", time.Minute, loader)
+		if err != nil || v != "loaded-key" {
+			t.Errorf("expected loaded-key, got %s - %v", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected the loader to be called once, got %d", loads)
+	}
+
+	loadErr := errors.New("load failed")
+	if _, err := strs.GetOrLoad("failed", time.Minute, func(string) (string, error) { return "", loadErr }); err != loadErr {
+		t.Errorf("expected the loader's error, got %v", err)
+	}
+	if _, found, _ := strs.Get("failed"); found {
+		t.Errorf("expected a failed load to not be cached")
+	}
+}
+
+func TestTyped_Batch(t *testing.T) {
+	c := newExpiryLRUInMemoryStore(t, time.Hour).(*GenericCache)
+	strs := NewTyped[string](c)
+	if err := strs.SetMany(map[string]string{"a": "A", "b": "B"}, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	values, err := strs.GetMany([]string{"a", "b", "c"})
+	if err != nil || len(values) != 2 || values["a"] != "A" || values["b"] != "B" {
+		t.Errorf("expected a and b, got %v - %v", values, err)
+	}
+
+	var requested []string
+	values, err = strs.GetManyOrLoad([]string{"a", "c", "d"}, time.Minute, func(missing []string) (map[string]string, error) {
+		requested = missing
+		return map[string]string{"c": "C"}, nil
+	})
+	sort.Strings(requested)
+	if err != nil || len(requested) != 2 || requested[0] != "c" || requested[1] != "d" {
+		t.Errorf("expected the loader to get c and d, got %v - %v", requested, err)
+	}
+	if len(values) != 2 || values["a"] != "A" || values["c"] != "C" {
+		t.Errorf("expected a and c, got %v", values)
+	}
+	if v, found, _ := strs.Get("c"); !found || v != "C" {
+		t.Errorf("expected the loaded value to be cached, got %s", v)
+	}
+}